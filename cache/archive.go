@@ -0,0 +1,265 @@
+// SPDX-FileCopyrightText: 2023 Iván Szkiba
+//
+// SPDX-License-Identifier: MIT
+
+package cache
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+type archiveFormat int
+
+const (
+	formatTar archiveFormat = iota
+	formatTarGzip
+	formatZip
+)
+
+// archiveStorage stores the history in a tar or zip archive with the directory backend layout.
+type archiveStorage struct {
+	filename string
+	format   archiveFormat
+}
+
+// archiveFormatOf returns the archive format denoted by the file name extension.
+func archiveFormatOf(filename string) (archiveFormat, bool) {
+	name := strings.ToLower(filename)
+
+	switch {
+	case strings.HasSuffix(name, ".tar"):
+		return formatTar, true
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return formatTarGzip, true
+	case strings.HasSuffix(name, ".zip"):
+		return formatZip, true
+	default:
+		return 0, false
+	}
+}
+
+func (s *archiveStorage) load(h *history) error {
+	content, err := os.ReadFile(s.filename)
+	if err != nil {
+		return err
+	}
+
+	var files map[string][]byte
+
+	if s.format == formatZip {
+		files, err = readZip(content)
+	} else {
+		files, err = readTar(content, s.format == formatTarGzip)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.filename, err)
+	}
+
+	data, found := files[manifestFilename]
+	if !found {
+		return fmt.Errorf("%s: %w", s.filename, errMissingManifest)
+	}
+
+	man, err := readManifest(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.filename, err)
+	}
+
+	return man.restore(h, func(name string) ([]byte, error) {
+		body, found := files[name]
+		if !found {
+			return nil, fmt.Errorf("%s: %w: %s", s.filename, os.ErrNotExist, name)
+		}
+
+		return body, nil
+	})
+}
+
+func (s *archiveStorage) save(h *history) error {
+	man := layout(h)
+
+	return writeFile(s.filename, func(writer io.Writer) error {
+		var buff bytes.Buffer
+
+		if err := man.write(&buff); err != nil {
+			return err
+		}
+
+		files := []archiveFile{{name: manifestFilename, body: buff.Bytes()}}
+
+		for _, entry := range man.Entries {
+			rep, _ := h.lookup(entry.Key)
+
+			files = append(files, archiveFile{name: entry.File, body: rep.body})
+		}
+
+		if s.format == formatZip {
+			return writeZip(writer, files)
+		}
+
+		return writeTar(writer, files, s.format == formatTarGzip)
+	})
+}
+
+func (s *archiveStorage) stat() (os.FileInfo, error) {
+	return os.Stat(s.filename)
+}
+
+type archiveFile struct {
+	name string
+	body []byte
+}
+
+func writeTar(writer io.Writer, files []archiveFile, compress bool) error {
+	var gz *gzip.Writer
+
+	if compress {
+		gz = gzip.NewWriter(writer)
+		writer = gz
+	}
+
+	out := tar.NewWriter(writer)
+
+	for _, file := range files {
+		hdr := &tar.Header{ //nolint:exhaustruct
+			Typeflag: tar.TypeReg,
+			Name:     file.name,
+			Mode:     filePerm,
+			Size:     int64(len(file.body)),
+			ModTime:  archiveTime,
+			Format:   tar.FormatPAX,
+		}
+
+		if err := out.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(file.body); err != nil {
+			return err
+		}
+	}
+
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	if gz != nil {
+		return gz.Close()
+	}
+
+	return nil
+}
+
+func readTar(content []byte, compressed bool) (map[string][]byte, error) {
+	var reader io.Reader = bytes.NewReader(content)
+
+	if compressed {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		defer gz.Close() //nolint:errcheck
+
+		reader = gz
+	}
+
+	inp := tar.NewReader(reader)
+	files := make(map[string][]byte)
+
+	for {
+		hdr, err := inp.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		body, err := io.ReadAll(inp)
+		if err != nil {
+			return nil, err
+		}
+
+		files[hdr.Name] = body
+	}
+
+	return files, nil
+}
+
+func writeZip(writer io.Writer, files []archiveFile) error {
+	out := zip.NewWriter(writer)
+
+	for _, file := range files {
+		hdr := &zip.FileHeader{ //nolint:exhaustruct
+			Name:     file.name,
+			Method:   zip.Deflate,
+			Modified: archiveTime,
+		}
+
+		hdr.SetMode(filePerm)
+
+		part, err := out.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+
+		if _, err := part.Write(file.body); err != nil {
+			return err
+		}
+	}
+
+	return out.Close()
+}
+
+func readZip(content []byte) (map[string][]byte, error) {
+	inp, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]byte)
+
+	for _, file := range inp.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+
+		part, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := io.ReadAll(part)
+
+		part.Close() //nolint:errcheck,gosec
+
+		if err != nil {
+			return nil, err
+		}
+
+		files[file.Name] = body
+	}
+
+	return files, nil
+}
+
+// archiveTime is the modification time of the archived files, fixed to keep archives reproducible.
+var archiveTime = time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+var errMissingManifest = errors.New("missing cache manifest")
@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchiveFormatOf(t *testing.T) {
+	t.Parallel()
+
+	for name, expected := range map[string]archiveFormat{
+		"vendor.tar":    formatTar,
+		"vendor.tar.gz": formatTarGzip,
+		"vendor.TGZ":    formatTarGzip,
+		"vendor.zip":    formatZip,
+	} {
+		format, found := archiveFormatOf(name)
+
+		assert.True(t, found, name)
+		assert.Equal(t, expected, format, name)
+	}
+
+	_, found := archiveFormatOf("vendor.eml")
+
+	assert.False(t, found)
+}
+
+func TestArchiveStorage(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	for _, name := range []string{"vendor.tar", "vendor.tar.gz", "vendor.zip"} {
+		filename := filepath.Join(dir, name)
+		store := newStorage(filename)
+
+		assert.IsType(t, &archiveStorage{}, store, name)
+
+		_, err := store.stat()
+
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		assert.ErrorIs(t, store.load(new(history)), os.ErrNotExist)
+
+		from := newTestHistory(t)
+
+		assert.NoError(t, store.save(from))
+
+		first, err := os.ReadFile(filename)
+
+		assert.NoError(t, err)
+
+		to := new(history)
+
+		assert.NoError(t, store.load(to), name)
+		assert.Equal(t, from.store, to.store, name)
+
+		assert.NoError(t, store.save(to))
+
+		second, err := os.ReadFile(filename)
+
+		assert.NoError(t, err)
+		assert.True(t, bytes.Equal(first, second), "reproducible %s", name)
+
+		assert.NoError(t, os.WriteFile(filename, []byte("garbage"), 0o600))
+		assert.Error(t, store.load(new(history)), name)
+
+		assert.NoError(t, (&archiveStorage{filename: filename, format: store.(*archiveStorage).format}).save(new(history)))
+		assert.NoError(t, store.load(new(history)), name)
+	}
+}
+
+func TestArchiveStorage_missing(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "vendor.zip")
+
+	assert.NoError(t, writeFile(filename, func(writer io.Writer) error {
+		return writeZip(writer, []archiveFile{{name: "foo", body: nil}})
+	}))
+
+	assert.ErrorIs(t, newStorage(filename).load(new(history)), errMissingManifest)
+
+	manifest := []byte(`{"entries":[{"key":"https://example.com","file":"example.com/index"}]}`)
+
+	assert.NoError(t, writeFile(filename, func(writer io.Writer) error {
+		return writeZip(writer, []archiveFile{{name: manifestFilename, body: manifest}})
+	}))
+
+	assert.ErrorIs(t, newStorage(filename).load(new(history)), os.ErrNotExist)
+
+	filename = filepath.Join(dir, "vendor.tar")
+
+	assert.NoError(t, writeFile(filename, func(writer io.Writer) error {
+		return writeTar(writer, []archiveFile{{name: manifestFilename, body: []byte("garbage")}}, false)
+	}))
+
+	assert.Error(t, newStorage(filename).load(new(history)))
+}
@@ -0,0 +1,70 @@
+// SPDX-FileCopyrightText: 2023 Iván Szkiba
+//
+// SPDX-License-Identifier: MIT
+
+package cache
+
+import (
+	"compress/gzip"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type compression int
+
+const (
+	compressNone compression = iota
+	compressGzip
+	compressZstd
+)
+
+// compressionOf returns the compression denoted by the file name extension.
+func compressionOf(filename string) compression {
+	name := strings.ToLower(filename)
+
+	switch {
+	case strings.HasSuffix(name, ".gz"):
+		return compressGzip
+	case strings.HasSuffix(name, ".zst"):
+		return compressZstd
+	default:
+		return compressNone
+	}
+}
+
+// decompressor returns a reader decompressing the content of reader.
+func (c compression) decompressor(reader io.Reader) (io.ReadCloser, error) {
+	switch c {
+	case compressGzip:
+		return gzip.NewReader(reader)
+	case compressZstd:
+		dec, err := zstd.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		return dec.IOReadCloser(), nil
+	default:
+		return io.NopCloser(reader), nil
+	}
+}
+
+// compressor returns a writer compressing into writer, it must be closed to flush the content.
+func (c compression) compressor(writer io.Writer) (io.WriteCloser, error) {
+	switch c {
+	case compressGzip:
+		return gzip.NewWriterLevel(writer, gzip.BestCompression)
+	case compressZstd:
+		return zstd.NewWriter(writer, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+	default:
+		return nopWriteCloser{writer}, nil
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
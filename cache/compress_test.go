@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressionOf(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, compressGzip, compressionOf("vendor.eml.gz"))
+	assert.Equal(t, compressZstd, compressionOf("vendor.eml.ZST"))
+	assert.Equal(t, compressNone, compressionOf("vendor.eml"))
+}
+
+func TestCompression(t *testing.T) {
+	t.Parallel()
+
+	content := bytes.Repeat([]byte("Hello World!"), 100)
+
+	for _, comp := range []compression{compressNone, compressGzip, compressZstd} {
+		var buff bytes.Buffer
+
+		writer, err := comp.compressor(&buff)
+
+		assert.NoError(t, err)
+
+		_, err = writer.Write(content)
+
+		assert.NoError(t, err)
+		assert.NoError(t, writer.Close())
+
+		if comp != compressNone {
+			assert.Less(t, buff.Len(), len(content))
+		}
+
+		reader, err := comp.decompressor(&buff)
+
+		assert.NoError(t, err)
+
+		decompressed, err := io.ReadAll(reader)
+
+		assert.NoError(t, err)
+		assert.NoError(t, reader.Close())
+		assert.Equal(t, content, decompressed)
+	}
+
+	_, err := compressGzip.decompressor(bytes.NewReader([]byte("garbage")))
+
+	assert.Error(t, err)
+}
+
+func TestFileStorage_compressed(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	for _, name := range []string{"vendor.eml.gz", "vendor.eml.zst"} {
+		filename := filepath.Join(dir, name)
+		store := newStorage(filename)
+		from := newTestHistory(t)
+
+		assert.NoError(t, store.save(from))
+
+		content, err := os.ReadFile(filename)
+
+		assert.NoError(t, err)
+		assert.NotContains(t, string(content), "Content-Location")
+
+		to := new(history)
+
+		assert.NoError(t, store.load(to))
+		assert.Equal(t, from.store, to.store)
+
+		assert.NoError(t, os.WriteFile(filename, []byte("garbage"), 0o600))
+		assert.Error(t, store.load(new(history)))
+	}
+}
@@ -0,0 +1,385 @@
+// SPDX-FileCopyrightText: 2023 Iván Szkiba
+//
+// SPDX-License-Identifier: MIT
+
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type config struct {
+	filename string
+	mode     mode
+	policy   *policy
+	routes   *routing
+	// seed is the HAR file recorded into the cache at startup.
+	seed string
+	// export is the HAR file the cache is exported into on stop.
+	export string
+}
+
+// merge overrides the settings of c with the non-empty settings of other.
+func (c *config) merge(other *config) {
+	if len(other.filename) != 0 {
+		c.filename = other.filename
+	}
+
+	if len(other.mode) != 0 {
+		c.mode = other.mode
+	}
+
+	if other.policy != nil {
+		c.policy = other.policy
+	}
+
+	if other.routes != nil {
+		c.routes = other.routes
+	}
+
+	if len(other.seed) != 0 {
+		c.seed = other.seed
+	}
+
+	if len(other.export) != 0 {
+		c.export = other.export
+	}
+}
+
+// configFile is the layout of the project configuration file.
+type configFile struct {
+	File   string `yaml:"file"`
+	Mode   string `yaml:"mode"`
+	Seed   string `yaml:"seed"`
+	Export string `yaml:"export"`
+	Hosts  struct {
+		Allow []string `yaml:"allow"`
+		Deny  []string `yaml:"deny"`
+	} `yaml:"hosts"`
+	Headers struct {
+		Allow []string `yaml:"allow"`
+		Deny  []string `yaml:"deny"`
+	} `yaml:"headers"`
+	ContentTypes []string `yaml:"content_types"`
+	Rewrite      []struct {
+		From string `yaml:"from"`
+		To   string `yaml:"to"`
+	} `yaml:"rewrite"`
+	Mirrors []struct {
+		From string   `yaml:"from"`
+		To   []string `yaml:"to"`
+	} `yaml:"mirrors"`
+}
+
+func configFromFile(filename string) (*config, error) {
+	file, err := os.Open(filename) //nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close() //nolint:errcheck
+
+	var content configFile
+
+	decoder := yaml.NewDecoder(file)
+
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(&content); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	cfg := new(config)
+
+	if len(content.File) != 0 {
+		cfg.filename = content.File
+
+		if !filepath.IsAbs(cfg.filename) {
+			cfg.filename = filepath.Join(filepath.Dir(filename), cfg.filename)
+		}
+	}
+
+	if len(content.Seed) != 0 {
+		cfg.seed = content.Seed
+
+		if !filepath.IsAbs(cfg.seed) {
+			cfg.seed = filepath.Join(filepath.Dir(filename), cfg.seed)
+		}
+	}
+
+	if len(content.Export) != 0 {
+		cfg.export = content.Export
+
+		if !filepath.IsAbs(cfg.export) {
+			cfg.export = filepath.Join(filepath.Dir(filename), cfg.export)
+		}
+	}
+
+	if len(content.Mode) != 0 {
+		if cfg.mode, err = parseMode(content.Mode); err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+	}
+
+	cfg.policy = defaultPolicy()
+
+	if content.Hosts.Allow != nil {
+		cfg.policy.allowHosts = content.Hosts.Allow
+	}
+
+	if content.Hosts.Deny != nil {
+		cfg.policy.denyHosts = content.Hosts.Deny
+	}
+
+	if content.Headers.Allow != nil {
+		cfg.policy.headers = content.Headers.Allow
+	}
+
+	if content.Headers.Deny != nil {
+		cfg.policy.denyHeaders = content.Headers.Deny
+	}
+
+	if content.ContentTypes != nil {
+		cfg.policy.contentTypes = content.ContentTypes
+	}
+
+	if len(content.Rewrite) != 0 || len(content.Mirrors) != 0 {
+		cfg.routes = new(routing)
+	}
+
+	for _, rule := range content.Rewrite {
+		rewrite, err := newRoute(rule.From, rule.To)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+
+		cfg.routes.rewrites = append(cfg.routes.rewrites, rewrite)
+	}
+
+	for _, rule := range content.Mirrors {
+		mirror, err := newRoute(rule.From, rule.To...)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+
+		cfg.routes.mirrors = append(cfg.routes.mirrors, mirror)
+	}
+
+	return cfg, nil
+}
+
+// findConfigFile returns the configuration file pointed by environment variable
+// or the one found next to the script or in the working directory.
+func findConfigFile(getenv func(string) string, args []string) (string, bool) {
+	if filename := getenv(envConfigKey); len(filename) != 0 {
+		return filename, true
+	}
+
+	dirs := []string{}
+
+	if script, found := findScript(args); found {
+		dirs = append(dirs, filepath.Dir(script))
+	}
+
+	dirs = append(dirs, ".")
+
+	for _, dir := range dirs {
+		filename := filepath.Join(dir, configFilename)
+
+		if _, err := os.Stat(filename); err == nil {
+			return filename, true
+		}
+	}
+
+	return "", false
+}
+
+// findScript returns the first positional command line argument after the run command
+// naming an existing regular file. Flags may precede or follow the script.
+func findScript(args []string) (string, bool) {
+	for idx, arg := range args {
+		if arg == runCommand {
+			args = args[idx+1:]
+
+			break
+		}
+	}
+
+	for idx := 0; idx < len(args); idx++ {
+		arg := args[idx]
+
+		if fileFlags[arg] {
+			idx++ // skip the value of the flag
+
+			continue
+		}
+
+		if strings.HasPrefix(arg, "-") {
+			continue
+		}
+
+		if info, err := os.Stat(arg); err == nil && info.Mode().IsRegular() {
+			return arg, true
+		}
+	}
+
+	return "", false
+}
+
+func configFromEnv(getenv func(string) string) (*config, error) {
+	cfg := &config{filename: getenv(envKey), seed: getenv(envSeedKey), export: getenv(envExportKey)}
+
+	if str := getenv(envModeKey); len(str) != 0 {
+		mod, err := parseMode(str)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.mode = mod
+	}
+
+	return cfg, nil
+}
+
+// parseConfigArgument parses the argument of the --out flag (the part after "cache=").
+// The argument is either a plain cache file name or comma separated key=value pairs.
+func parseConfigArgument(arg string) (*config, error) {
+	cfg := new(config)
+
+	if len(arg) == 0 {
+		return cfg, nil
+	}
+
+	if !strings.Contains(arg, "=") {
+		cfg.filename = arg
+
+		return cfg, nil
+	}
+
+	for _, pair := range strings.Split(arg, ",") {
+		key, value, found := strings.Cut(pair, "=")
+		if !found {
+			return nil, fmt.Errorf("%w: %s", errInvalidConfigArgument, pair)
+		}
+
+		switch strings.TrimSpace(key) {
+		case "file":
+			cfg.filename = value
+		case "mode":
+			mod, err := parseMode(value)
+			if err != nil {
+				return nil, err
+			}
+
+			cfg.mode = mod
+		case "seed":
+			cfg.seed = value
+		case "export":
+			cfg.export = value
+		default:
+			return nil, fmt.Errorf("%w: unknown key %s", errInvalidConfigArgument, key)
+		}
+	}
+
+	return cfg, nil
+}
+
+// findConfigArgument looks for the cache output in command line arguments.
+// Modules are loaded before k6 creates the outputs, so the arguments have to be parsed
+// early, at extension initialization time.
+func findConfigArgument(args []string) (string, bool) {
+	for idx := 0; idx < len(args); idx++ {
+		arg := args[idx]
+
+		if arg == "--" {
+			break
+		}
+
+		var value string
+
+		switch {
+		case arg == "--out" || arg == "-o":
+			if idx+1 >= len(args) {
+				return "", false
+			}
+
+			idx++
+			value = args[idx]
+		case strings.HasPrefix(arg, "--out="):
+			value = strings.TrimPrefix(arg, "--out=")
+		case strings.HasPrefix(arg, "-o"):
+			value = strings.TrimPrefix(strings.TrimPrefix(arg, "-o"), "=")
+		default:
+			continue
+		}
+
+		if value == moduleName {
+			return "", true
+		}
+
+		if strings.HasPrefix(value, moduleName+"=") {
+			return strings.TrimPrefix(value, moduleName+"="), true
+		}
+	}
+
+	return "", false
+}
+
+func configFromArgs(args []string) (*config, error) {
+	arg, found := findConfigArgument(args)
+	if !found {
+		return new(config), nil
+	}
+
+	return parseConfigArgument(arg)
+}
+
+// loadConfig returns the configuration from the --out flag,
+// environment variables and the configuration file act as fallback.
+func loadConfig(getenv func(string) string, args []string) (*config, error) {
+	cfg := new(config)
+
+	if filename, found := findConfigFile(getenv, args); found {
+		fromFile, err := configFromFile(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.merge(fromFile)
+	}
+
+	fromEnv, err := configFromEnv(getenv)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.merge(fromEnv)
+
+	fromArgs, err := configFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.merge(fromArgs)
+
+	return cfg, nil
+}
+
+const (
+	configFilename = "." + xk6Name + ".yaml"
+	runCommand     = "run"
+)
+
+// fileFlags lists the k6 run flags taking a file name as separate argument.
+var fileFlags = map[string]bool{"-c": true, "--config": true, "--summary-export": true, "--console-output": true}
+
+var (
+	errInvalidConfigArgument = errors.New("invalid cache output argument")
+	errLateConfig            = errors.New("cache output argument differs from the one detected at startup")
+)
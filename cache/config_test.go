@@ -0,0 +1,265 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseConfigArgument(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := parseConfigArgument("")
+
+	assert.NoError(t, err)
+	assert.Equal(t, &config{}, cfg)
+
+	cfg, err = parseConfigArgument("vendor.eml")
+
+	assert.NoError(t, err)
+	assert.Equal(t, &config{filename: "vendor.eml"}, cfg)
+
+	cfg, err = parseConfigArgument("file=vendor.eml,mode=offline")
+
+	assert.NoError(t, err)
+	assert.Equal(t, &config{filename: "vendor.eml", mode: modeOffline}, cfg)
+
+	cfg, err = parseConfigArgument("file=vendor.eml,seed=capture.har,export=vendor.har")
+
+	assert.NoError(t, err)
+	assert.Equal(t, &config{filename: "vendor.eml", seed: "capture.har", export: "vendor.har"}, cfg)
+
+	_, err = parseConfigArgument("file=vendor.eml,offline")
+
+	assert.ErrorIs(t, err, errInvalidConfigArgument)
+
+	_, err = parseConfigArgument("foo=bar")
+
+	assert.ErrorIs(t, err, errInvalidConfigArgument)
+
+	_, err = parseConfigArgument("mode=foo")
+
+	assert.ErrorIs(t, err, errInvalidMode)
+}
+
+func TestFindConfigArgument(t *testing.T) {
+	t.Parallel()
+
+	for _, args := range [][]string{
+		{"run", "--out", "cache=vendor.eml", "script.js"},
+		{"run", "--out=cache=vendor.eml", "script.js"},
+		{"run", "-o", "cache=vendor.eml", "script.js"},
+		{"run", "-ocache=vendor.eml", "script.js"},
+		{"run", "-o=cache=vendor.eml", "script.js"},
+		{"run", "-o", "json=out.json", "--out", "cache=vendor.eml", "script.js"},
+	} {
+		arg, found := findConfigArgument(args)
+
+		assert.True(t, found, args)
+		assert.Equal(t, "vendor.eml", arg, args)
+	}
+
+	arg, found := findConfigArgument([]string{"run", "--out", "cache", "script.js"})
+
+	assert.True(t, found)
+	assert.Empty(t, arg)
+
+	for _, args := range [][]string{
+		{"run", "script.js"},
+		{"run", "--out", "json=cache.json", "script.js"},
+		{"run", "--out"},
+		{"run", "--", "--out", "cache=vendor.eml"},
+	} {
+		_, found := findConfigArgument(args)
+
+		assert.False(t, found, args)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Parallel()
+
+	env := map[string]string{envKey: "env.eml", envModeKey: "replay"}
+	getenv := func(key string) string { return env[key] }
+
+	cfg, err := loadConfig(getenv, []string{"run", "script.js"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, &config{filename: "env.eml", mode: modeReplay}, cfg)
+
+	cfg, err = loadConfig(getenv, []string{"run", "--out", "cache=arg.eml", "script.js"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, &config{filename: "arg.eml", mode: modeReplay}, cfg)
+
+	cfg, err = loadConfig(getenv, []string{"run", "--out", "cache=mode=offline", "script.js"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, &config{filename: "env.eml", mode: modeOffline}, cfg)
+
+	_, err = loadConfig(getenv, []string{"run", "--out", "cache=foo=bar", "script.js"})
+
+	assert.Error(t, err)
+
+	env[envModeKey] = "foo"
+
+	_, err = loadConfig(getenv, nil)
+
+	assert.ErrorIs(t, err, errInvalidMode)
+}
+
+func TestConfigFromFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, configFilename)
+
+	content := `
+file: vendor.eml
+mode: offline
+seed: capture.har
+export: vendor.har
+hosts:
+  allow: ["*.k6.io"]
+  deny: [example.com]
+headers:
+  allow: [Content-Type]
+  deny: [X-Secret]
+content_types: [text/javascript]
+rewrite:
+  - from: https://jslib.k6.io/
+    to: https://mirror.example.com/jslib/
+mirrors:
+  - from: https://jslib.k6.io/
+    to: [https://backup.example.com/jslib/]
+`
+
+	assert.NoError(t, os.WriteFile(filename, []byte(content), 0o600))
+
+	cfg, err := configFromFile(filename)
+
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "vendor.eml"), cfg.filename)
+	assert.Equal(t, modeOffline, cfg.mode)
+	assert.Equal(t, filepath.Join(dir, "capture.har"), cfg.seed)
+	assert.Equal(t, filepath.Join(dir, "vendor.har"), cfg.export)
+	assert.Equal(t, []string{"*.k6.io"}, cfg.policy.allowHosts)
+	assert.Equal(t, []string{"example.com"}, cfg.policy.denyHosts)
+	assert.Equal(t, []string{"Content-Type"}, cfg.policy.headers)
+	assert.Equal(t, []string{"X-Secret"}, cfg.policy.denyHeaders)
+	assert.Equal(t, []string{"text/javascript"}, cfg.policy.contentTypes)
+	assert.Equal(t, []*route{{from: "https://jslib.k6.io/", to: []string{"https://mirror.example.com/jslib/"}}}, cfg.routes.rewrites)
+	assert.Equal(t, []*route{{from: "https://jslib.k6.io/", to: []string{"https://backup.example.com/jslib/"}}}, cfg.routes.mirrors)
+
+	assert.NoError(t, os.WriteFile(filename, []byte("mode: replay\n"), 0o600))
+
+	cfg, err = configFromFile(filename)
+
+	assert.NoError(t, err)
+	assert.Empty(t, cfg.filename)
+	assert.Equal(t, defaultPolicy(), cfg.policy)
+
+	assert.NoError(t, os.WriteFile(filename, []byte("foo: bar\n"), 0o600))
+
+	_, err = configFromFile(filename)
+
+	assert.Error(t, err)
+
+	assert.NoError(t, os.WriteFile(filename, []byte("rewrite: [{from: https://jslib.k6.io/}]\n"), 0o600))
+
+	_, err = configFromFile(filename)
+
+	assert.ErrorIs(t, err, errInvalidRoute)
+
+	assert.NoError(t, os.WriteFile(filename, []byte("mode: foo\n"), 0o600))
+
+	_, err = configFromFile(filename)
+
+	assert.ErrorIs(t, err, errInvalidMode)
+
+	_, err = configFromFile(filepath.Join(dir, "missing.yaml"))
+
+	assert.Error(t, err)
+}
+
+func TestFindConfigFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	script := filepath.Join(dir, "script.js")
+	getenv := func(string) string { return "" }
+
+	assert.NoError(t, os.WriteFile(script, nil, 0o600))
+
+	_, found := findConfigFile(getenv, []string{"run", script})
+
+	assert.False(t, found)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, configFilename), nil, 0o600))
+
+	filename, found := findConfigFile(getenv, []string{"run", script})
+
+	assert.True(t, found)
+	assert.Equal(t, filepath.Join(dir, configFilename), filename)
+
+	for _, args := range [][]string{
+		{"run", script, "--vus", "10"},
+		{"run", script, "--out", "cache"},
+		{"run", "--config", filepath.Join(t.TempDir(), "options.json"), script, "--quiet"},
+		{"--verbose", "run", "--out", "cache=file=vendor.eml", script},
+	} {
+		filename, found = findConfigFile(getenv, args)
+
+		assert.True(t, found, args)
+		assert.Equal(t, filepath.Join(dir, configFilename), filename, args)
+	}
+
+	options := filepath.Join(t.TempDir(), "options.json")
+
+	assert.NoError(t, os.WriteFile(options, nil, 0o600))
+
+	script, found = findScript([]string{"run", "-c", options, script})
+
+	assert.True(t, found)
+	assert.Equal(t, filepath.Join(dir, "script.js"), script)
+
+	filename, found = findConfigFile(func(string) string { return "custom.yaml" }, []string{"run", script})
+
+	assert.True(t, found)
+	assert.Equal(t, "custom.yaml", filename)
+}
+
+func TestLoadConfig_file(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "custom.yaml")
+
+	assert.NoError(t, os.WriteFile(filename, []byte("file: file.eml\nmode: offline\n"), 0o600))
+
+	env := map[string]string{envConfigKey: filename}
+	getenv := func(key string) string { return env[key] }
+
+	cfg, err := loadConfig(getenv, nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "file.eml"), cfg.filename)
+	assert.Equal(t, modeOffline, cfg.mode)
+	assert.NotNil(t, cfg.policy)
+
+	env[envKey] = "env.eml"
+
+	cfg, err = loadConfig(getenv, []string{"--out", "cache=mode=update"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "env.eml", cfg.filename)
+	assert.Equal(t, modeUpdate, cfg.mode)
+
+	env[envConfigKey] = filepath.Join(dir, "missing.yaml")
+
+	_, err = loadConfig(getenv, nil)
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,396 @@
+// SPDX-FileCopyrightText: 2023 Iván Szkiba
+//
+// SPDX-License-Identifier: MIT
+
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// dirStorage stores the history as a directory tree laid out by host and path,
+// for example vendor/jslib.k6.io/k6-utils/1.4.0/index.js. Bodies are stored as plain files,
+// keys and headers are stored in a manifest file in the root of the directory.
+type dirStorage struct {
+	dirname string
+}
+
+type manifest struct {
+	Version int              `json:"version"`
+	Entries []*manifestEntry `json:"entries"`
+}
+
+type manifestEntry struct {
+	Key    string      `json:"key"`
+	File   string      `json:"file"`
+	Header http.Header `json:"header"`
+}
+
+func (s *dirStorage) manifestName() string {
+	return filepath.Join(s.dirname, manifestFilename)
+}
+
+func (s *dirStorage) readManifest() (*manifest, error) {
+	file, err := os.Open(s.manifestName())
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close() //nolint:errcheck
+
+	man, err := readManifest(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.manifestName(), err)
+	}
+
+	return man, nil
+}
+
+func (s *dirStorage) load(h *history) error {
+	man, err := s.readManifest()
+	if err != nil {
+		return err
+	}
+
+	return man.restore(h, func(name string) ([]byte, error) {
+		return os.ReadFile(filepath.Join(s.dirname, filepath.FromSlash(name)))
+	})
+}
+
+// save replaces the tree so that every manifest written refers to complete files: new bodies are
+// written in place, changed ones into a staging directory first. The staged manifest is switched
+// to the final one after the changed bodies have been replaced, so an interrupted save leaves either
+// the old or the new tree behind.
+func (s *dirStorage) save(h *history) error {
+	if err := os.MkdirAll(s.dirname, dirPerm); err != nil {
+		return err
+	}
+
+	old, err := s.readManifest()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	man := layout(h)
+
+	staged, err := s.stage(man, h)
+	if err != nil {
+		return err
+	}
+
+	if staged != man {
+		if err := writeFile(s.manifestName(), staged.write); err != nil {
+			return err
+		}
+
+		for idx, entry := range man.Entries {
+			if staged.Entries[idx].File == entry.File {
+				continue
+			}
+
+			rep, _ := h.lookup(entry.Key)
+
+			if err := writeFile(filepath.Join(s.dirname, filepath.FromSlash(entry.File)), writeBytes(rep.body)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := writeFile(s.manifestName(), man.write); err != nil {
+		return err
+	}
+
+	if old != nil {
+		s.cleanup(old, man)
+	}
+
+	s.cleanupStaging()
+
+	return nil
+}
+
+// stage writes the bodies of the manifest entries missing from the tree, the changed bodies
+// are written into a new staging directory. It returns the manifest referring to the staged files,
+// or man itself if nothing has been staged.
+func (s *dirStorage) stage(man *manifest, h *history) (*manifest, error) {
+	staged := man
+	staging := ""
+
+	for idx, entry := range man.Entries {
+		rep, _ := h.lookup(entry.Key)
+
+		filename := filepath.Join(s.dirname, filepath.FromSlash(entry.File))
+
+		current, err := os.ReadFile(filename) //nolint:gosec
+		if err == nil && bytes.Equal(current, rep.body) {
+			continue
+		}
+
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+
+		if err == nil {
+			if len(staging) == 0 {
+				dir, err := os.MkdirTemp(s.dirname, stagingPrefix+"*")
+				if err != nil {
+					return nil, err
+				}
+
+				staging = filepath.Base(dir)
+				staged = &manifest{Version: man.Version, Entries: append([]*manifestEntry(nil), man.Entries...)}
+			}
+
+			moved := *entry
+			moved.File = path.Join(staging, entry.File)
+			staged.Entries[idx] = &moved
+
+			filename = filepath.Join(s.dirname, filepath.FromSlash(moved.File))
+		}
+
+		if err := os.MkdirAll(filepath.Dir(filename), dirPerm); err != nil {
+			return nil, err
+		}
+
+		if err := writeFile(filename, writeBytes(rep.body)); err != nil {
+			return nil, err
+		}
+	}
+
+	return staged, nil
+}
+
+// cleanupStaging removes the staging directories, including the ones left behind by interrupted saves.
+func (s *dirStorage) cleanupStaging() {
+	dirs, err := filepath.Glob(filepath.Join(s.dirname, stagingPrefix+"*"))
+	if err != nil {
+		return
+	}
+
+	for _, dir := range dirs {
+		os.RemoveAll(dir) //nolint:errcheck,gosec
+	}
+}
+
+// cleanup removes the files (and the emptied directories) referenced only by the old manifest.
+func (s *dirStorage) cleanup(old, current *manifest) {
+	used := make(map[string]bool, len(current.Entries))
+
+	for _, entry := range current.Entries {
+		used[entry.File] = true
+	}
+
+	for _, entry := range old.Entries {
+		if used[entry.File] || !filepath.IsLocal(filepath.FromSlash(entry.File)) {
+			continue
+		}
+
+		os.Remove(filepath.Join(s.dirname, filepath.FromSlash(entry.File))) //nolint:errcheck,gosec
+
+		for dir := path.Dir(entry.File); dir != "."; dir = path.Dir(dir) {
+			if os.Remove(filepath.Join(s.dirname, filepath.FromSlash(dir))) != nil {
+				break
+			}
+		}
+	}
+}
+
+func (s *dirStorage) stat() (os.FileInfo, error) {
+	return os.Stat(s.manifestName())
+}
+
+func readManifest(reader io.Reader) (*manifest, error) {
+	man := new(manifest)
+
+	if err := json.NewDecoder(reader).Decode(man); err != nil {
+		return nil, err
+	}
+
+	if man.Version == 0 {
+		man.Version = manifestBaseVersion
+	}
+
+	if err := checkFormatVersion(man.Version); err != nil {
+		return nil, err
+	}
+
+	return man, nil
+}
+
+func (m *manifest) write(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+
+	return encoder.Encode(m)
+}
+
+// restore adds the entries of the manifest to the history, reading the bodies using read.
+func (m *manifest) restore(h *history, read func(name string) ([]byte, error)) error {
+	for _, entry := range m.Entries {
+		if !filepath.IsLocal(filepath.FromSlash(entry.File)) {
+			return fmt.Errorf("%w: %s", errInvalidEntryFile, entry.File)
+		}
+
+		body, err := read(entry.File)
+		if err != nil {
+			return err
+		}
+
+		header := entry.Header
+		if header == nil {
+			header = http.Header{}
+		}
+
+		header.Set(hdrContentLocation, entry.Key)
+
+		if err := h.restore(&reply{header: header, body: body}, m.Version); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// layout returns the manifest of the history, assigning a unique file name to every entry.
+func layout(h *history) *manifest {
+	man := &manifest{Version: formatVersion}
+	files := map[string]bool{manifestFilename: true}
+	dirs := make(map[string]bool)
+
+	for _, key := range h.keys() {
+		if len(key) == 0 {
+			continue
+		}
+
+		rep, _ := h.lookup(key)
+
+		base := entryFile(key)
+		name := base
+
+		for idx := 1; conflicts(name, files, dirs); idx++ {
+			name = suffixed(base, idx, files)
+		}
+
+		files[name] = true
+
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+
+		header := cloneHeader(rep.header)
+
+		for _, computed := range []string{hdrContentLocation, hdrContentLength, hdrContentDisposition} {
+			header.Del(computed)
+		}
+
+		man.Entries = append(man.Entries, &manifestEntry{Key: key, File: name, Header: header})
+	}
+
+	return man
+}
+
+// conflicts reports whether name is already used as file or directory,
+// or any of its parent directories is used as file.
+func conflicts(name string, files, dirs map[string]bool) bool {
+	if files[name] || dirs[name] {
+		return true
+	}
+
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if files[dir] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// suffixed returns base with a numeric suffix added to its first segment used as file,
+// or to its last segment if none of its parents is used as file.
+func suffixed(base string, idx int, files map[string]bool) string {
+	suffix := "~" + strconv.Itoa(idx)
+	segments := strings.Split(base, "/")
+
+	for last := 1; last < len(segments); last++ {
+		if files[strings.Join(segments[:last], "/")] {
+			segments[last-1] += suffix
+
+			return strings.Join(segments, "/")
+		}
+	}
+
+	return base + suffix
+}
+
+// entryFile returns the slash separated relative file name of a cache key.
+func entryFile(key string) string {
+	loc, err := url.Parse(key)
+	if err != nil || len(loc.Host) == 0 {
+		return sanitizeSegment(key)
+	}
+
+	segments := []string{sanitizeSegment(loc.Host)}
+
+	clean := path.Clean("/" + loc.Path)
+
+	for _, segment := range strings.Split(clean, "/") {
+		if len(segment) != 0 {
+			segments = append(segments, sanitizeSegment(segment))
+		}
+	}
+
+	if clean == "/" || strings.HasSuffix(loc.Path, "/") {
+		segments = append(segments, indexFilename)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// sanitizeSegment replaces the characters not allowed in file names on common platforms.
+func sanitizeSegment(segment string) string {
+	segment = strings.Map(func(r rune) rune {
+		if r < ' ' || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+
+		return r
+	}, segment)
+
+	if segment == "." || segment == ".." || len(segment) == 0 {
+		return "_" + segment
+	}
+
+	return segment
+}
+
+func writeBytes(body []byte) func(io.Writer) error {
+	return func(writer io.Writer) error {
+		_, err := writer.Write(body)
+
+		return err
+	}
+}
+
+const (
+	manifestFilename = "manifest.json"
+	stagingPrefix    = ".staging-"
+	indexFilename    = "index"
+	dirPerm          = 0o755
+	// manifestBaseVersion is the format version of manifests written without version.
+	manifestBaseVersion = 2
+)
+
+var errInvalidEntryFile = errors.New("invalid cache entry file name")
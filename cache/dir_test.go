@@ -0,0 +1,206 @@
+package cache
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestHistory(t *testing.T) *history {
+	t.Helper()
+
+	cache := new(history)
+
+	for _, key := range []string{
+		"https://jslib.k6.io/k6-utils/1.4.0/index.js?_k6=1",
+		"https://example.com?_k6=1",
+		"https://example.com/foo?_k6=1",
+		"https://example.com/foo/bar.js?_k6=1",
+	} {
+		loc, err := url.Parse(key)
+
+		assert.NoError(t, err)
+
+		cache.put(loc, &reply{header: http.Header{"Content-Type": {"text/javascript"}}, body: []byte("// " + key)})
+	}
+
+	return cache
+}
+
+func TestEntryFile(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "jslib.k6.io/k6-utils/1.4.0/index.js", entryFile("https://jslib.k6.io/k6-utils/1.4.0/index.js?_k6=1"))
+	assert.Equal(t, "example.com/index", entryFile("https://example.com?_k6=1"))
+	assert.Equal(t, "example.com/foo/index", entryFile("https://example.com/foo/"))
+	assert.Equal(t, "example.com_8080/x.js", entryFile("https://example.com:8080/x.js"))
+	assert.Equal(t, "example.com/x.js", entryFile("https://example.com/../../x.js"))
+	assert.Equal(t, "example.com/a_b.js", entryFile("https://example.com/a%3Fb.js"))
+	assert.Equal(t, "foo_bar", entryFile("foo/bar"))
+	assert.Equal(t, "_..", sanitizeSegment(".."))
+	assert.Equal(t, "_", sanitizeSegment(""))
+}
+
+func TestLayout(t *testing.T) {
+	t.Parallel()
+
+	man := layout(newTestHistory(t))
+
+	files := map[string]string{}
+
+	for _, entry := range man.Entries {
+		files[entry.Key] = entry.File
+
+		assert.NotContains(t, entry.Header, "Content-Location")
+		assert.NotContains(t, entry.Header, "Content-Length")
+		assert.Contains(t, entry.Header, "Integrity")
+	}
+
+	assert.Equal(t, map[string]string{
+		"https://jslib.k6.io/k6-utils/1.4.0/index.js?_k6=1": "jslib.k6.io/k6-utils/1.4.0/index.js",
+		"https://example.com?_k6=1":                         "example.com/index",
+		"https://example.com/foo?_k6=1":                     "example.com/foo~1",
+		"https://example.com/foo/bar.js?_k6=1":              "example.com/foo/bar.js",
+	}, files)
+
+	cache := new(history)
+
+	for _, key := range []string{"https://example.com/foo", "https://example.com/foo/bar.js", "https://example.com/foo~1"} {
+		loc, _ := url.Parse(key)
+
+		cache.put(loc, &reply{header: nil, body: nil})
+	}
+
+	files = map[string]string{}
+
+	for _, entry := range layout(cache).Entries {
+		files[entry.Key] = entry.File
+	}
+
+	assert.Equal(t, map[string]string{
+		"https://example.com/foo":        "example.com/foo",
+		"https://example.com/foo/bar.js": "example.com/foo~1/bar.js",
+		"https://example.com/foo~1":      "example.com/foo~1~1",
+	}, files)
+}
+
+func TestDirStorage(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "vendor")
+	store := newStorage(dir + "/")
+
+	_, err := store.stat()
+
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.ErrorIs(t, store.load(new(history)), os.ErrNotExist)
+
+	from := newTestHistory(t)
+
+	assert.NoError(t, store.save(from))
+
+	body, err := os.ReadFile(filepath.Join(dir, "jslib.k6.io", "k6-utils", "1.4.0", "index.js"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "// https://jslib.k6.io/k6-utils/1.4.0/index.js?_k6=1", string(body))
+
+	_, err = store.stat()
+
+	assert.NoError(t, err)
+
+	to := new(history)
+
+	assert.NoError(t, newStorage(dir).load(to))
+	assert.Equal(t, from.store, to.store)
+
+	smaller := new(history)
+
+	loc, _ := url.Parse("https://example.com?_k6=1")
+
+	smaller.put(loc, &reply{header: nil, body: []byte("index")})
+
+	assert.NoError(t, store.save(smaller))
+
+	assert.NoDirExists(t, filepath.Join(dir, "jslib.k6.io"))
+	assert.NoFileExists(t, filepath.Join(dir, "example.com", "foo"))
+	assert.FileExists(t, filepath.Join(dir, "example.com", "index"))
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "example.com", "index"), []byte("tampered"), 0o600))
+	assert.ErrorIs(t, store.load(new(history)), errIntegrityMismatch)
+
+	manifest := `{"version":4,"entries":[{"key":"https://example.com","file":"example.com/index"}]}`
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, manifestFilename), []byte(manifest), 0o600))
+	assert.ErrorIs(t, store.load(new(history)), errMissingIntegrity)
+
+	manifest = `{"entries":[{"key":"https://example.com","file":"../passwd"}]}`
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, manifestFilename), []byte(manifest), 0o600))
+	assert.ErrorIs(t, store.load(new(history)), errInvalidEntryFile)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, manifestFilename), []byte("garbage"), 0o600))
+	assert.Error(t, store.load(new(history)))
+	assert.Error(t, store.save(smaller))
+}
+
+func TestDirStorage_interrupted(t *testing.T) {
+	t.Parallel()
+
+	store := &dirStorage{dirname: filepath.Join(t.TempDir(), "vendor")}
+
+	from := newTestHistory(t)
+
+	assert.NoError(t, store.save(from))
+
+	changed := newTestHistory(t)
+
+	loc, _ := url.Parse("https://jslib.k6.io/k6-utils/1.4.0/index.js?_k6=1")
+
+	changed.put(loc, &reply{header: http.Header{"Content-Type": {"text/javascript"}}, body: []byte("// changed")})
+
+	loc, _ = url.Parse("https://example.com/new.js?_k6=1")
+
+	changed.put(loc, &reply{header: http.Header{"Content-Type": {"text/javascript"}}, body: []byte("// new")})
+
+	man := layout(changed)
+
+	// interrupted before switching to the staged manifest
+	staged, err := store.stage(man, changed)
+
+	assert.NoError(t, err)
+	assert.NotSame(t, man, staged)
+
+	to := new(history)
+
+	assert.NoError(t, store.load(to))
+	assert.Equal(t, from.store, to.store)
+
+	// interrupted after switching to the staged manifest
+	assert.NoError(t, writeFile(store.manifestName(), staged.write))
+
+	to = new(history)
+
+	assert.NoError(t, store.load(to))
+	assert.Equal(t, changed.store, to.store)
+
+	assert.NoError(t, store.save(changed))
+
+	to = new(history)
+
+	assert.NoError(t, store.load(to))
+	assert.Equal(t, changed.store, to.store)
+
+	leftover, err := filepath.Glob(filepath.Join(store.dirname, stagingPrefix+"*"))
+
+	assert.NoError(t, err)
+	assert.Empty(t, leftover)
+
+	body, err := os.ReadFile(filepath.Join(store.dirname, "jslib.k6.io", "k6-utils", "1.4.0", "index.js"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "// changed", string(body))
+}
@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: 2023 Iván Szkiba
+//
+// SPDX-License-Identifier: MIT
+
+package cache
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// writeFile writes filename atomically. The content is written into a temporary file
+// in the same directory, which is synced and renamed into place only when complete.
+// This way the file is always either the old version or the complete new one.
+func writeFile(filename string, write func(io.Writer) error) error {
+	perm := os.FileMode(filePerm)
+
+	if info, err := os.Stat(filename); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	tmpname := file.Name()
+
+	defer os.Remove(tmpname) //nolint:errcheck
+
+	if err := write(file); err != nil {
+		file.Close() //nolint:errcheck,gosec
+
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close() //nolint:errcheck,gosec
+
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmpname, perm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpname, filename)
+}
+
+const filePerm = 0o644
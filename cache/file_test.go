@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "vendor.eml")
+
+	write := func(content string) func(io.Writer) error {
+		return func(writer io.Writer) error {
+			_, err := writer.Write([]byte(content))
+
+			return err
+		}
+	}
+
+	assert.NoError(t, writeFile(filename, write("old")))
+
+	content, err := os.ReadFile(filename)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "old", string(content))
+
+	errWrite := errors.New("write error")
+
+	err = writeFile(filename, func(writer io.Writer) error {
+		_, _ = writer.Write([]byte("partial"))
+
+		return errWrite
+	})
+
+	assert.ErrorIs(t, err, errWrite)
+
+	content, err = os.ReadFile(filename)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "old", string(content))
+
+	assert.NoError(t, writeFile(filename, write("new")))
+
+	content, err = os.ReadFile(filename)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "new", string(content))
+
+	entries, err := os.ReadDir(dir)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	assert.Error(t, writeFile(filepath.Join(dir, "missing", "vendor.eml"), write("new")))
+}
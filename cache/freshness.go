@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: 2023 Iván Szkiba
+//
+// SPDX-License-Identifier: MIT
+
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheControl parses the Cache-Control header into directive name and value pairs.
+func cacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+
+	for _, line := range header.Values(hdrCacheControl) {
+		for _, directive := range strings.Split(line, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if len(name) == 0 {
+				continue
+			}
+
+			directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+
+	return directives
+}
+
+// noStore reports whether the response must not be stored.
+func noStore(header http.Header) bool {
+	_, found := cacheControl(header)["no-store"]
+
+	return found
+}
+
+// fresh reports whether a stored response is still fresh at the given time.
+// The response age is calculated from the Date header, which holds the fetch time,
+// the lifetime is taken from the Cache-Control or Expires header. The immutable directive
+// does not extend the lifetime (RFC 8246), it only applies while the response is fresh.
+func fresh(header http.Header, now time.Time) bool {
+	date, err := http.ParseTime(header.Get(hdrDate))
+	if err != nil {
+		return false
+	}
+
+	directives := cacheControl(header)
+
+	if _, found := directives["no-cache"]; found {
+		return false
+	}
+
+	if value, found := directives["max-age"]; found {
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return false
+		}
+
+		return now.Before(date.Add(time.Duration(seconds) * time.Second))
+	}
+
+	expires := header.Get(hdrExpires)
+	if len(expires) == 0 {
+		return false
+	}
+
+	until, err := http.ParseTime(expires)
+	if err != nil {
+		return false
+	}
+
+	return now.Before(until)
+}
+
+const (
+	hdrCacheControl = "Cache-Control"
+	hdrExpires      = "Expires"
+	hdrDate         = "Date"
+)
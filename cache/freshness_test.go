@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheControl(t *testing.T) {
+	t.Parallel()
+
+	header := http.Header{}
+
+	header.Add(hdrCacheControl, `public, Max-Age=60`)
+	header.Add(hdrCacheControl, `no-store, foo="bar", ,`)
+
+	assert.Equal(t, map[string]string{"public": "", "max-age": "60", "no-store": "", "foo": "bar"}, cacheControl(header))
+	assert.True(t, noStore(header))
+	assert.False(t, noStore(http.Header{}))
+}
+
+func TestFresh(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, time.May, 1, 12, 0, 0, 0, time.UTC)
+	date := now.Add(-time.Minute).Format(http.TimeFormat)
+
+	for _, tc := range []struct {
+		header http.Header
+		fresh  bool
+	}{
+		{http.Header{}, false},
+		{http.Header{hdrDate: {"invalid"}, hdrCacheControl: {"max-age=3600"}}, false},
+		{http.Header{hdrDate: {date}}, false},
+		{http.Header{hdrDate: {date}, hdrCacheControl: {"max-age=3600"}}, true},
+		{http.Header{hdrDate: {date}, hdrCacheControl: {"max-age=30"}}, false},
+		{http.Header{hdrDate: {date}, hdrCacheControl: {"max-age=foo"}}, false},
+		{http.Header{hdrDate: {date}, hdrCacheControl: {"max-age=3600, no-cache"}}, false},
+		{http.Header{hdrDate: {date}, hdrCacheControl: {"max-age=3600, immutable"}}, true},
+		{http.Header{hdrDate: {date}, hdrCacheControl: {"max-age=30, immutable"}}, false},
+		{http.Header{hdrDate: {date}, hdrCacheControl: {"immutable"}}, false},
+		{http.Header{hdrDate: {date}, hdrExpires: {now.Add(time.Hour).Format(http.TimeFormat)}}, true},
+		{http.Header{hdrDate: {date}, hdrExpires: {now.Add(-time.Hour).Format(http.TimeFormat)}}, false},
+		{http.Header{hdrDate: {date}, hdrExpires: {"0"}}, false},
+		{http.Header{hdrDate: {date}, hdrExpires: {now.Add(time.Hour).Format(http.TimeFormat)}, hdrCacheControl: {"max-age=0"}}, false},
+	} {
+		assert.Equal(t, tc.fresh, fresh(tc.header, now), tc.header)
+	}
+}
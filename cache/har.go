@@ -0,0 +1,379 @@
+// SPDX-FileCopyrightText: 2023 Iván Szkiba
+//
+// SPDX-License-Identifier: MIT
+
+package cache
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// har is the subset of the HTTP Archive 1.2 format used by xk6-cache,
+// see http://www.softwareishard.com/blog/har-12-spec/
+type har struct {
+	Log *harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string      `json:"version"`
+	Creator *harCreator `json:"creator"`
+	Entries []*harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string       `json:"startedDateTime"`
+	Time            float64      `json:"time"`
+	Request         *harRequest  `json:"request"`
+	Response        *harResponse `json:"response"`
+	Cache           struct{}     `json:"cache"`
+	Timings         *harTimings  `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string       `json:"method"`
+	URL         string       `json:"url"`
+	HTTPVersion string       `json:"httpVersion"`
+	Cookies     []*harRecord `json:"cookies"`
+	Headers     []*harRecord `json:"headers"`
+	QueryString []*harRecord `json:"queryString"`
+	HeadersSize int          `json:"headersSize"`
+	BodySize    int          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int          `json:"status"`
+	StatusText  string       `json:"statusText"`
+	HTTPVersion string       `json:"httpVersion"`
+	Cookies     []*harRecord `json:"cookies"`
+	Headers     []*harRecord `json:"headers"`
+	Content     *harContent  `json:"content"`
+	RedirectURL string       `json:"redirectURL"`
+	HeadersSize int          `json:"headersSize"`
+	BodySize    int          `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harRecord struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+func readHAR(reader io.Reader) (*har, error) {
+	doc := new(har)
+
+	if err := json.NewDecoder(reader).Decode(doc); err != nil {
+		return nil, err
+	}
+
+	if doc.Log == nil {
+		return nil, fmt.Errorf("%w: missing log", errInvalidHAR)
+	}
+
+	return doc, nil
+}
+
+// seed records the responses of a HAR file into the history,
+// applying the same rules as for the responses fetched from the network.
+func (tw *tripperware) seed(filename string) error {
+	file, err := os.Open(filename) //nolint:gosec
+	if err != nil {
+		return err
+	}
+
+	defer file.Close() //nolint:errcheck
+
+	doc, err := readHAR(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+
+	seeded := 0
+
+	for _, entry := range doc.Log.Entries {
+		req, res, err := entry.exchange()
+		if err != nil {
+			tw.logger.WithError(err).Warn("HAR entry skipped")
+
+			continue
+		}
+
+		if req.Method != http.MethodGet || !tw.policy.cacheable(req.URL) || !tw.shouldStore(res) {
+			continue
+		}
+
+		if _, err := tw.record(req, res); err != nil {
+			return err
+		}
+
+		seeded++
+	}
+
+	tw.logger.WithField("size", seeded).WithField("file", filename).Debug("cache seeded")
+
+	return nil
+}
+
+// exchange returns the request and the response of the entry.
+func (e *harEntry) exchange() (*http.Request, *http.Response, error) {
+	if e.Request == nil || e.Response == nil {
+		return nil, nil, fmt.Errorf("%w: missing request or response", errInvalidHAR)
+	}
+
+	loc, err := url.Parse(e.Request.URL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	loc.Fragment, loc.RawFragment = "", ""
+
+	req := &http.Request{Method: strings.ToUpper(e.Request.Method), URL: loc, Header: harHeader(e.Request.Headers)} //nolint:exhaustruct
+
+	body, err := e.Response.Content.decode()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", err, loc)
+	}
+
+	header := harHeader(e.Response.Headers)
+
+	// HAR content is already decoded
+	header.Del(hdrContentEncoding)
+	header.Set(hdrContentLength, strconv.Itoa(len(body)))
+
+	if len(header.Get(hdrContentType)) == 0 && len(e.Response.Content.MimeType) != 0 {
+		header.Set(hdrContentType, e.Response.Content.MimeType)
+	}
+
+	res := &http.Response{ //nolint:exhaustruct
+		Status:        http.StatusText(e.Response.Status),
+		StatusCode:    e.Response.Status,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+
+	return req, res, nil
+}
+
+func (c *harContent) decode() ([]byte, error) {
+	if c == nil {
+		return nil, fmt.Errorf("%w: missing content", errInvalidHAR)
+	}
+
+	if len(c.Text) == 0 && c.Size > 0 {
+		return nil, fmt.Errorf("%w: missing content text", errInvalidHAR)
+	}
+
+	if len(c.Encoding) == 0 {
+		return []byte(c.Text), nil
+	}
+
+	if !strings.EqualFold(c.Encoding, encodingBase64) {
+		return nil, fmt.Errorf("%w: %s", errUnsupportedEncoding, c.Encoding)
+	}
+
+	return base64.StdEncoding.DecodeString(c.Text)
+}
+
+// exportHAR returns the entries of the history as HAR document.
+func (c *history) exportHAR() (*har, error) {
+	if err := c.materialize(); err != nil {
+		return nil, err
+	}
+
+	doc := &har{Log: &harLog{
+		Version: harVersion,
+		Creator: &harCreator{Name: xk6Name, Version: moduleVersion()},
+		Entries: []*harEntry{},
+	}}
+
+	for _, key := range c.keys() {
+		if len(key) == 0 {
+			continue
+		}
+
+		rep, _ := c.lookup(key)
+
+		doc.Log.Entries = append(doc.Log.Entries, harEntryOf(key, rep))
+	}
+
+	return doc, nil
+}
+
+func harEntryOf(key string, rep *reply) *harEntry {
+	started := archiveTime
+
+	if date, err := http.ParseTime(rep.header.Get(hdrDate)); err == nil {
+		started = date
+	}
+
+	loc, err := url.Parse(key)
+	if err != nil {
+		loc = &url.URL{} //nolint:exhaustruct
+	}
+
+	query := []*harRecord{}
+
+	for name, values := range loc.Query() {
+		for _, value := range values {
+			query = append(query, &harRecord{Name: name, Value: value})
+		}
+	}
+
+	sort.SliceStable(query, func(i, j int) bool { return query[i].Name < query[j].Name })
+
+	header := cloneHeader(rep.header)
+
+	header.Del(hdrContentLocation)
+	header.Del(hdrContentDisposition)
+	header.Del(hdrStatus)
+
+	content := &harContent{Size: len(rep.body), MimeType: rep.header.Get(hdrContentType)} //nolint:exhaustruct
+
+	if utf8.Valid(rep.body) {
+		content.Text = string(rep.body)
+	} else {
+		content.Text = base64.StdEncoding.EncodeToString(rep.body)
+		content.Encoding = encodingBase64
+	}
+
+	return &harEntry{
+		StartedDateTime: started.UTC().Format(time.RFC3339),
+		Request: &harRequest{
+			Method:      http.MethodGet,
+			URL:         key,
+			HTTPVersion: harHTTPVersion,
+			Cookies:     []*harRecord{},
+			Headers:     []*harRecord{},
+			QueryString: query,
+			HeadersSize: -1,
+			BodySize:    0,
+		},
+		Response: &harResponse{
+			Status:      rep.code(),
+			StatusText:  http.StatusText(rep.code()),
+			HTTPVersion: harHTTPVersion,
+			Cookies:     []*harRecord{},
+			Headers:     harRecords(header),
+			Content:     content,
+			RedirectURL: rep.header.Get(hdrLocation),
+			HeadersSize: -1,
+			BodySize:    len(rep.body),
+		},
+		Timings: &harTimings{Send: 0, Wait: 0, Receive: 0},
+	}
+}
+
+func (doc *har) write(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+
+	return encoder.Encode(doc)
+}
+
+// export writes the history into a HAR file.
+func (tw *tripperware) export(filename string) error {
+	doc, err := tw.history.exportHAR()
+	if err != nil {
+		return err
+	}
+
+	tw.logger.WithField("size", len(doc.Log.Entries)).WithField("file", filename).Debug("cache exported")
+
+	return writeFile(filename, doc.write)
+}
+
+// moduleVersion returns the version of xk6-cache built into the binary.
+func moduleVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return harUnknownVersion
+	}
+
+	for _, dep := range append([]*debug.Module{&info.Main}, info.Deps...) {
+		if dep.Path == xk6ModulePath && len(dep.Version) != 0 {
+			return dep.Version
+		}
+	}
+
+	return harUnknownVersion
+}
+
+// harRecords converts HTTP header to HAR name-value pairs sorted by name.
+func harRecords(header http.Header) []*harRecord {
+	names := make([]string, 0, len(header))
+
+	for name := range header {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	records := []*harRecord{}
+
+	for _, name := range names {
+		for _, value := range header[name] {
+			records = append(records, &harRecord{Name: name, Value: value})
+		}
+	}
+
+	return records
+}
+
+// harHeader converts HAR name-value pairs to HTTP header, HTTP/2 pseudo headers are dropped.
+func harHeader(records []*harRecord) http.Header {
+	header := http.Header{}
+
+	for _, record := range records {
+		if len(record.Name) == 0 || strings.HasPrefix(record.Name, ":") {
+			continue
+		}
+
+		header.Add(record.Name, record.Value)
+	}
+
+	return header
+}
+
+const (
+	hdrContentEncoding = "Content-Encoding"
+	harVersion         = "1.2"
+	harHTTPVersion     = "HTTP/1.1"
+	harUnknownVersion  = "(devel)"
+	xk6ModulePath      = "github.com/szkiba/" + xk6Name
+)
+
+var errInvalidHAR = errors.New("invalid HAR file")
@@ -0,0 +1,189 @@
+package cache
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+const testHAR = `{
+  "log": {
+    "version": "1.2",
+    "creator": {"name": "test", "version": "1.0"},
+    "entries": [
+      {
+        "request": {"method": "GET", "url": "https://jslib.k6.io/k6-utils/1.4.0/index.js", "headers": []},
+        "response": {
+          "status": 200,
+          "headers": [
+            {"name": ":status", "value": "200"},
+            {"name": "content-type", "value": "text/javascript"},
+            {"name": "content-encoding", "value": "gzip"},
+            {"name": "etag", "value": "\"abc\""},
+            {"name": "x-served-by", "value": "cache"}
+          ],
+          "content": {"size": 12, "mimeType": "text/javascript", "text": "Hello World!"}
+        }
+      },
+      {
+        "request": {"method": "GET", "url": "https://example.com/module.wasm"},
+        "response": {
+          "status": 200,
+          "headers": [],
+          "content": {"size": 4, "mimeType": "application/wasm", "text": "AGFzbQ==", "encoding": "base64"}
+        }
+      },
+      {
+        "request": {"method": "GET", "url": "https://example.com/missing.js"},
+        "response": {"status": 404, "headers": [], "content": {"size": 0, "mimeType": "text/plain"}}
+      },
+      {
+        "request": {"method": "POST", "url": "https://example.com/post.js"},
+        "response": {"status": 200, "headers": [], "content": {"size": 2, "mimeType": "text/javascript", "text": "{}"}}
+      },
+      {
+        "request": {"method": "GET", "url": "https://example.com/style.css"},
+        "response": {"status": 200, "headers": [], "content": {"size": 10, "mimeType": "text/css"}}
+      }
+    ]
+  }
+}`
+
+func TestTripperware_seed(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), "capture.har")
+
+	assert.NoError(t, os.WriteFile(filename, []byte(testHAR), 0o600))
+
+	tw := newTripperware(modeRecord, newTransport(t), logrus.StandardLogger())
+
+	assert.NoError(t, tw.seed(filename))
+	assert.Equal(t, int64(2), tw.recorded.Load())
+
+	rep, found := tw.history.lookup("https://jslib.k6.io/k6-utils/1.4.0/index.js?_k6=1")
+
+	assert.True(t, found)
+	assert.Equal(t, "Hello World!", string(rep.body))
+	assert.Equal(t, "text/javascript", rep.header.Get(hdrContentType))
+	assert.Equal(t, `"abc"`, rep.header.Get(hdrETag))
+	assert.Equal(t, "12", rep.header.Get(hdrContentLength))
+	assert.Empty(t, rep.header.Get(hdrContentEncoding))
+	assert.Empty(t, rep.header.Get("X-Served-By"))
+
+	rep, found = tw.history.lookup("https://example.com/module.wasm?_k6=1")
+
+	assert.True(t, found)
+	assert.Equal(t, []byte("\x00asm"), rep.body)
+	assert.Equal(t, "application/wasm", rep.header.Get(hdrContentType))
+
+	_, found = tw.history.lookup("https://example.com/missing.js?_k6=1")
+
+	assert.False(t, found)
+
+	_, found = tw.history.lookup("https://example.com/post.js?_k6=1")
+
+	assert.False(t, found)
+
+	assert.Error(t, tw.seed(filepath.Join(t.TempDir(), "missing.har")))
+
+	assert.NoError(t, os.WriteFile(filename, []byte(`{}`), 0o600))
+	assert.ErrorIs(t, tw.seed(filename), errInvalidHAR)
+}
+
+func TestHarContent_decode(t *testing.T) {
+	t.Parallel()
+
+	_, err := (*harContent)(nil).decode()
+
+	assert.ErrorIs(t, err, errInvalidHAR)
+
+	_, err = (&harContent{Size: 3, Text: "foo", Encoding: "gzip"}).decode() //nolint:exhaustruct
+
+	assert.ErrorIs(t, err, errUnsupportedEncoding)
+
+	body, err := (&harContent{Size: 0}).decode() //nolint:exhaustruct
+
+	assert.NoError(t, err)
+	assert.Empty(t, body)
+}
+
+func TestReadHAR(t *testing.T) {
+	t.Parallel()
+
+	doc, err := readHAR(strings.NewReader(testHAR))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "1.2", doc.Log.Version)
+	assert.Len(t, doc.Log.Entries, 5)
+
+	_, err = readHAR(strings.NewReader("garbage"))
+
+	assert.Error(t, err)
+}
+
+func TestHistory_exportHAR(t *testing.T) {
+	t.Parallel()
+
+	from := newTestHistory(t)
+
+	loc, _ := url.Parse("https://example.com/module.wasm?_k6=1")
+
+	from.put(loc, &reply{header: http.Header{hdrContentType: {"application/wasm"}}, body: []byte("\x00asm\xff")})
+
+	doc, err := from.exportHAR()
+
+	assert.NoError(t, err)
+
+	assert.Equal(t, harVersion, doc.Log.Version)
+	assert.Equal(t, xk6Name, doc.Log.Creator.Name)
+	assert.Len(t, doc.Log.Entries, len(from.keys())-1)
+
+	var buff bytes.Buffer
+
+	assert.NoError(t, doc.write(&buff))
+
+	filename := filepath.Join(t.TempDir(), "export.har")
+
+	assert.NoError(t, os.WriteFile(filename, buff.Bytes(), 0o600))
+
+	tw := newTripperware(modeRecord, newTransport(t), logrus.StandardLogger())
+
+	assert.NoError(t, tw.seed(filename))
+
+	for _, key := range from.keys() {
+		want, _ := from.lookup(key)
+		got, found := tw.history.lookup(key)
+
+		assert.True(t, found, key)
+		assert.Equal(t, want.body, got.body, key)
+		assert.Equal(t, want.header.Get(hdrIntegrity), got.header.Get(hdrIntegrity), key)
+	}
+}
+
+func TestHarEntryOf(t *testing.T) {
+	t.Parallel()
+
+	rep := &reply{header: http.Header{}, body: []byte("Hello World!")}
+
+	rep.header.Set(hdrContentType, "text/javascript")
+	rep.header.Set(hdrContentLocation, "https://example.com/index.js?b=2&a=1")
+	rep.header.Set(hdrDate, "Mon, 02 Jan 2023 15:04:05 GMT")
+
+	entry := harEntryOf("https://example.com/index.js?b=2&a=1", rep)
+
+	assert.Equal(t, "2023-01-02T15:04:05Z", entry.StartedDateTime)
+	assert.Equal(t, []*harRecord{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}}, entry.Request.QueryString)
+	assert.Equal(t, http.StatusOK, entry.Response.Status)
+	assert.Equal(t, "Hello World!", entry.Response.Content.Text)
+	assert.Empty(t, entry.Response.Content.Encoding)
+	assert.Equal(t, "text/javascript", entry.Response.Content.MimeType)
+	assert.NotContains(t, entry.Response.Headers, &harRecord{Name: hdrContentLocation, Value: "https://example.com/index.js?b=2&a=1"})
+}
@@ -5,6 +5,8 @@
 package cache
 
 import (
+	"bytes"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
@@ -16,31 +18,57 @@ import (
 	"net/url"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"unicode/utf8"
 )
 
 type reply struct {
 	header http.Header
 	body   []byte
+	// status is the HTTP status code of the entry, zero means 200.
+	status int
+}
+
+// code returns the HTTP status code of the entry.
+func (r *reply) code() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+
+	return r.status
 }
 
 type history struct {
 	store map[string]*reply
 	mu    sync.RWMutex
+	// outdated is set when entries were restored from an older format version
+	// or headers were redacted from them, so the stored cache differs from the saved one.
+	outdated bool
+	// lazy holds the entries of the cache file not loaded yet, nil if there is none.
+	lazy *indexed
 }
 
 func (c *history) put(key *url.URL, value *reply) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	c.putLocked(key, value)
+}
+
+// putLocked is put for callers holding the write lock. Entries are stored under
+// the canonical form of the key.
+func (c *history) putLocked(key *url.URL, value *reply) {
 	if value.header == nil {
 		value.header = http.Header{}
 	}
 
+	key = canonicalURL(key)
 	str := key.String()
 
 	value.header.Set(hdrContentLocation, str)
 	value.header.Set(hdrContentLength, strconv.Itoa(len(value.body)))
+	value.header.Set(hdrIntegrity, integrity(value.body))
 
 	loc := *key
 
@@ -50,40 +78,216 @@ func (c *history) put(key *url.URL, value *reply) {
 
 	value.header.Set(hdrContentDisposition, cd)
 
-	if c.store == nil {
-		c.store = make(map[string]*reply)
+	if code := value.code(); code != http.StatusOK {
+		value.header.Set(hdrStatus, strconv.Itoa(code))
+	} else {
+		value.header.Del(hdrStatus)
+	}
 
-		hdr := http.Header{}
-		hdr.Set(hdrContentType, cacheBodyContentType)
+	c.describeLocked()
 
-		entry := &reply{
-			header: hdr,
-			body:   []byte(cacheBody),
-		}
+	c.store[str] = value
 
-		c.store[""] = entry
+	if c.lazy != nil {
+		delete(c.lazy.entries, str)
+		c.closeIndexLocked()
 	}
+}
 
-	c.store[str] = value
+// remove deletes the entry stored under the given (canonical) key string.
+func (c *history) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.store, key)
+
+	if c.lazy != nil {
+		delete(c.lazy.entries, key)
+		c.closeIndexLocked()
+	}
 }
 
+// describeLocked creates the store with the description entry.
+func (c *history) describeLocked() {
+	if c.store != nil {
+		return
+	}
+
+	c.store = make(map[string]*reply)
+
+	hdr := http.Header{}
+	hdr.Set(hdrContentType, cacheBodyContentType)
+
+	entry := &reply{
+		header: hdr,
+		body:   []byte(cacheBody),
+	}
+
+	c.store[""] = entry
+}
+
+// get is find without error reporting, entries failed to load are reported as missing.
 func (c *history) get(key *url.URL) (*reply, bool) {
+	ret, ok, err := c.find(key)
+
+	return ret, ok && err == nil
+}
+
+// lookup returns the entry stored under the given (canonical) key string.
+func (c *history) lookup(key string) (*reply, bool) {
+	ret, ok, err := c.findKey(key)
+
+	return ret, ok && err == nil
+}
+
+// find returns the entry stored for the canonical form of the given URL,
+// loading it from the cache file if it is indexed but not loaded yet.
+func (c *history) find(key *url.URL) (*reply, bool, error) {
+	return c.findKey(canonicalKey(key))
+}
+
+// findKey is find by (canonical) key string.
+func (c *history) findKey(key string) (*reply, bool, error) {
+	c.mu.RLock()
+	ret, ok := c.store[key]
+	pending := c.lazy != nil
+	c.mu.RUnlock()
+
+	if ok || !pending {
+		return ret, ok, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ret, ok := c.store[key]; ok {
+		return ret, true, nil
+	}
+
+	return c.loadLocked(key)
+}
+
+// restore puts a previously stored entry of the given format version,
+// migrating it to the current version and verifying its integrity.
+func (c *history) restore(rep *reply, version int) error {
+	key, err := prepare(rep, version)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.putLocked(key, rep)
+
+	if version < formatVersion {
+		c.outdated = true
+	}
+
+	return nil
+}
+
+// prepare migrates a previously stored entry of the given format version to the current version
+// and verifies its integrity. It returns the key of the entry.
+func prepare(rep *reply, version int) (*url.URL, error) {
+	key, err := url.Parse(rep.header.Get(hdrContentLocation))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrate(rep, version); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, key)
+	}
+
+	metadata := rep.header.Get(hdrIntegrity)
+
+	switch {
+	case len(metadata) != 0:
+		if err := verifyIntegrity(metadata, rep.body); err != nil {
+			return nil, fmt.Errorf("%w: %s", err, key)
+		}
+	case version > 1: // only version 1 entries may lack the digest
+		return nil, fmt.Errorf("%w: %s", errMissingIntegrity, key)
+	}
+
+	if rep.status, err = statusOf(rep.header); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, key)
+	}
+
+	return key, nil
+}
+
+// redact removes the headers denied by the policy from the stored entries.
+func (c *history) redact(pol *policy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, entry := range c.store {
+		if len(key) == 0 {
+			continue
+		}
+
+		c.redactLocked(entry.header, pol)
+	}
+
+	if c.lazy != nil {
+		for _, entry := range c.lazy.entries {
+			c.redactLocked(entry.header, pol)
+		}
+	}
+}
+
+func (c *history) redactLocked(header http.Header, pol *policy) {
+	for name := range header {
+		if pol.redacts(name) {
+			header.Del(name)
+			c.outdated = true
+		}
+	}
+}
+
+// isOutdated reports whether the history has to be saved to upgrade the stored cache.
+func (c *history) isOutdated() bool {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	if c.store == nil {
-		return nil, false
+	return c.outdated
+}
+
+// merge adds the entries of other missing from c.
+func (c *history) merge(other *history) {
+	other.mu.RLock()
+	defer other.mu.RUnlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(other.store) == 0 {
+		return
 	}
 
-	ret, ok := c.store[key.String()]
+	c.describeLocked()
+
+	for key, value := range other.store {
+		if _, found := c.store[key]; found {
+			continue
+		}
+
+		if c.lazy != nil {
+			if _, found := c.lazy.entries[key]; found {
+				continue
+			}
+		}
 
-	return ret, ok
+		c.store[key] = value
+	}
 }
 
-func (c *history) marshalHeader(writer io.Writer) error {
+func (c *history) marshalHeader(writer io.Writer, boundary string) error {
 	hdr := http.Header{}
 	hdr.Set(hdrSubject, cacheSubject)
-	hdr.Set(hdrContentType, mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": cacheBoundary}))
+	hdr.Set(hdrFormatVersion, strconv.Itoa(formatVersion))
+	hdr.Set(hdrContentType, mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": boundary}))
 
 	if err := hdr.Write(writer); err != nil {
 		return err
@@ -94,11 +298,8 @@ func (c *history) marshalHeader(writer io.Writer) error {
 	return err
 }
 
-func (c *history) marshal(writer io.Writer) error {
-	if err := c.marshalHeader(writer); err != nil {
-		return err
-	}
-
+// keys returns the sorted keys of the stored entries.
+func (c *history) keys() []string {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
@@ -108,21 +309,55 @@ func (c *history) marshal(writer io.Writer) error {
 		keys = append(keys, key)
 	}
 
+	if c.lazy != nil {
+		for key := range c.lazy.entries {
+			keys = append(keys, key)
+		}
+	}
+
 	sort.Strings(keys)
 
-	out := multipart.NewWriter(writer)
+	return keys
+}
 
-	out.SetBoundary(cacheBoundary) // nolint:errcheck
+func (c *history) marshal(writer io.Writer) error {
+	if err := c.materialize(); err != nil {
+		return err
+	}
+
+	keys := c.keys()
 
-	for _, key := range keys {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	headers := make([]http.Header, len(keys))
+	bodies := make([][]byte, len(keys))
+
+	for idx, key := range keys {
 		entry := c.store[key]
 
-		part, err := out.CreatePart(textproto.MIMEHeader(entry.header))
+		headers[idx], bodies[idx] = encodeBody(entry.header, entry.body)
+	}
+
+	boundary := safeBoundary(bodies)
+
+	if err := c.marshalHeader(writer, boundary); err != nil {
+		return err
+	}
+
+	out := multipart.NewWriter(writer)
+
+	if err := out.SetBoundary(boundary); err != nil {
+		return err
+	}
+
+	for idx := range keys {
+		part, err := out.CreatePart(textproto.MIMEHeader(headers[idx]))
 		if err != nil {
 			return err
 		}
 
-		if _, err := part.Write(entry.body); err != nil {
+		if _, err := part.Write(bodies[idx]); err != nil {
 			return err
 		}
 	}
@@ -130,31 +365,58 @@ func (c *history) marshal(writer io.Writer) error {
 	return out.Close()
 }
 
-func (c *history) unmarshalHeader(reader io.Reader) (string, io.Reader, error) {
+// safeBoundary returns the standard boundary, or if any of the bodies contains it,
+// the first numbered variant of it not contained by any of the bodies.
+func safeBoundary(bodies [][]byte) string {
+	boundary := cacheBoundary
+
+	for idx := 1; collides(boundary, bodies); idx++ {
+		boundary = cacheBoundary + strconv.Itoa(idx)
+	}
+
+	return boundary
+}
+
+func collides(boundary string, bodies [][]byte) bool {
+	for _, body := range bodies {
+		if bytes.Contains(body, []byte(boundary)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *history) unmarshalHeader(reader io.Reader) (string, int, io.Reader, error) {
 	msg, err := mail.ReadMessage(reader)
 	if err != nil {
-		return "", nil, err
+		return "", 0, nil, err
+	}
+
+	version, err := parseFormatVersion(msg.Header.Get(hdrFormatVersion))
+	if err != nil {
+		return "", 0, nil, err
 	}
 
 	mediatype, params, err := mime.ParseMediaType(msg.Header.Get(hdrContentType))
 	if err != nil {
-		return "", nil, err
+		return "", 0, nil, err
 	}
 
 	if mediatype != "multipart/mixed" {
-		return "", nil, errInvalidCacheContentType
+		return "", 0, nil, errInvalidCacheContentType
 	}
 
 	boundary, ok := params["boundary"]
 	if !ok {
-		return "", nil, fmt.Errorf("%w: missing boundary parameter", errInvalidCacheContentType)
+		return "", 0, nil, fmt.Errorf("%w: missing boundary parameter", errInvalidCacheContentType)
 	}
 
-	return boundary, msg.Body, nil
+	return boundary, version, msg.Body, nil
 }
 
 func (c *history) unmarshal(reader io.Reader) error {
-	boundary, body, err := c.unmarshalHeader(reader)
+	boundary, version, body, err := c.unmarshalHeader(reader)
 	if err != nil {
 		return err
 	}
@@ -171,33 +433,99 @@ func (c *history) unmarshal(reader io.Reader) error {
 			return err
 		}
 
-		cl := part.Header.Get(hdrContentLength)
-		if len(cl) == 0 {
-			continue
+		loc := part.Header.Get(hdrContentLocation)
+		if len(loc) == 0 {
+			continue // description
 		}
 
-		rep := new(reply)
+		size, err := strconv.Atoi(part.Header.Get(hdrContentLength))
+		if err != nil {
+			return fmt.Errorf("%w: %s", errContentLength, loc)
+		}
 
-		rep.header = http.Header(part.Header)
+		rep := new(reply)
 
 		body, err := io.ReadAll(part)
 		if err != nil {
 			return err
 		}
 
-		rep.body = body
-
-		key, err := url.Parse(rep.header.Get(hdrContentLocation))
+		rep.header, rep.body, err = decodeBody(http.Header(part.Header), body)
 		if err != nil {
-			return err
+			return fmt.Errorf("%w: %s", err, loc)
 		}
 
-		c.put(key, rep)
+		if len(rep.body) != size {
+			return fmt.Errorf("%w: %s", errContentLength, loc)
+		}
+
+		if err := c.restore(rep, version); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// encodeBody returns the part header and content of an entry. Bodies which are not
+// valid UTF-8 text are base64 encoded.
+func encodeBody(header http.Header, body []byte) (http.Header, []byte) {
+	if utf8.Valid(body) && bytes.IndexByte(body, 0) < 0 {
+		return header, body
+	}
+
+	header = cloneHeader(header)
+	header.Set(hdrContentTransferEncoding, encodingBase64)
+
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(body)))
+
+	base64.StdEncoding.Encode(encoded, body)
+
+	var buff bytes.Buffer
+
+	for len(encoded) > base64LineLength {
+		buff.Write(encoded[:base64LineLength])
+		buff.WriteString("\r\n")
+
+		encoded = encoded[base64LineLength:]
+	}
+
+	buff.Write(encoded)
+
+	return header, buff.Bytes()
+}
+
+// decodeBody reverts encodeBody.
+func decodeBody(header http.Header, content []byte) (http.Header, []byte, error) {
+	encoding := header.Get(hdrContentTransferEncoding)
+	if len(encoding) == 0 {
+		return header, content, nil
+	}
+
+	if !strings.EqualFold(encoding, encodingBase64) {
+		return nil, nil, fmt.Errorf("%w: %s", errUnsupportedEncoding, encoding)
+	}
+
+	header.Del(hdrContentTransferEncoding)
+
+	content = bytes.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || r == ' ' || r == '\t' {
+			return -1
+		}
+
+		return r
+	}, content)
+
+	body := make([]byte, base64.StdEncoding.DecodedLen(len(content)))
+
+	n, err := base64.StdEncoding.Decode(body, content)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return header, body[:n], nil
+}
+
 const (
 	cacheBoundary         = "______________________________o_o______________________________"
 	hdrContentLocation    = "Content-Location"
@@ -210,4 +538,14 @@ const (
 	cacheBody             = `This is ` + xk6Name + `'s standard email format cache file that can be viewed with an email client such as Mozilla Thunderbird. Modules stored as email attachments.`
 )
 
-var errInvalidCacheContentType = errors.New("invalid cache Content-Type")
+const (
+	hdrContentTransferEncoding = "Content-Transfer-Encoding"
+	encodingBase64             = "base64"
+	base64LineLength           = 76
+)
+
+var (
+	errInvalidCacheContentType = errors.New("invalid cache Content-Type")
+	errUnsupportedEncoding     = errors.New("unsupported Content-Transfer-Encoding")
+	errContentLength           = errors.New("invalid Content-Length")
+)
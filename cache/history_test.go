@@ -39,6 +39,7 @@ func TestHistory_put(t *testing.T) {
 	assert.Equal(t, `attachment; filename="https://example.com"`, rep.header.Get("Content-Disposition"))
 	assert.Contains(t, rep.header, "Content-Length")
 	assert.Equal(t, strconv.Itoa(len("Hello World!")), rep.header.Get("Content-Length"))
+	assert.Equal(t, integrity([]byte("Hello World!")), rep.header.Get("Integrity"))
 }
 
 func TestHistory_get(t *testing.T) {
@@ -60,8 +61,8 @@ func TestHistory_marshalHeader(t *testing.T) {
 
 	var buff bytes.Buffer
 
-	assert.NoError(t, cache.marshalHeader(&buff))
-	assert.Equal(t, "Content-Type: multipart/mixed; boundary=______________________________o_o______________________________\r\nSubject: xk6-cache\r\n\r\n", buff.String())
+	assert.NoError(t, cache.marshalHeader(&buff, cacheBoundary))
+	assert.Equal(t, "Content-Type: multipart/mixed; boundary=______________________________o_o______________________________\r\nSubject: xk6-cache\r\nX-Xk6-Cache-Version: 4\r\n\r\n", buff.String())
 }
 
 func TestHistory_marshalError(t *testing.T) {
@@ -71,7 +72,7 @@ func TestHistory_marshalError(t *testing.T) {
 
 	file, _ := os.Open("history_test.go")
 
-	assert.Error(t, cache.marshalHeader(file))
+	assert.Error(t, cache.marshalHeader(file, cacheBoundary))
 	assert.Error(t, cache.marshal(file))
 }
 
@@ -82,7 +83,7 @@ func TestHistory_unmarshalError(t *testing.T) {
 
 	content := ``
 
-	_, _, err := cache.unmarshalHeader(strings.NewReader(content))
+	_, _, _, err := cache.unmarshalHeader(strings.NewReader(content))
 
 	assert.Error(t, err)
 	assert.Error(t, cache.unmarshal(strings.NewReader(content)))
@@ -121,3 +122,352 @@ func TestHistory_marshal(t *testing.T) {
 	assert.Greater(t, comIndex, 0)
 	assert.Less(t, comIndex, netIndex)
 }
+
+func TestHistory_merge(t *testing.T) {
+	t.Parallel()
+
+	var cache, other history
+
+	com, _ := url.Parse("https://example.com")
+	net, _ := url.Parse("https://example.net")
+
+	cache.merge(&other)
+
+	assert.Nil(t, cache.store)
+
+	cache.put(com, &reply{header: nil, body: []byte("mine")})
+	other.put(com, &reply{header: nil, body: []byte("theirs")})
+	other.put(net, &reply{header: nil, body: []byte("theirs")})
+
+	cache.merge(&other)
+
+	rep, found := cache.get(com)
+
+	assert.True(t, found)
+	assert.Equal(t, "mine", string(rep.body))
+
+	rep, found = cache.get(net)
+
+	assert.True(t, found)
+	assert.Equal(t, "theirs", string(rep.body))
+
+	var empty history
+
+	empty.merge(&other)
+
+	assert.Len(t, empty.store, len(other.store))
+
+	undescribed := &history{store: map[string]*reply{net.String(): {header: http.Header{}, body: []byte("theirs")}}} //nolint:exhaustruct
+
+	var fresh history
+
+	fresh.merge(undescribed)
+
+	description, found := fresh.store[""]
+
+	assert.True(t, found)
+	assert.Equal(t, cacheBody, string(description.body))
+}
+
+func TestHistory_unmarshalIntegrity(t *testing.T) {
+	t.Parallel()
+
+	var cache history
+
+	loc, _ := url.Parse("https://example.com")
+
+	cache.put(loc, &reply{header: nil, body: []byte("Hello World!")})
+
+	var buff bytes.Buffer
+
+	assert.NoError(t, cache.marshal(&buff))
+
+	tampered := bytes.Replace(buff.Bytes(), []byte("Hello World!"), []byte("Hello Wordl!"), 1)
+
+	var other history
+
+	err := other.unmarshal(bytes.NewReader(tampered))
+
+	assert.ErrorIs(t, err, errIntegrityMismatch)
+	assert.ErrorContains(t, err, "https://example.com")
+
+	stripped := bytes.Replace(tampered, []byte("Integrity:"), []byte("X-Foo:"), 1)
+
+	err = other.unmarshal(bytes.NewReader(stripped))
+
+	assert.ErrorIs(t, err, errMissingIntegrity)
+	assert.ErrorContains(t, err, "https://example.com")
+
+	legacy := bytes.Replace(stripped, []byte("X-Xk6-Cache-Version: 4\r\n"), nil, 1)
+
+	assert.NoError(t, other.unmarshal(bytes.NewReader(legacy)))
+}
+
+func TestHistory_marshalBinary(t *testing.T) {
+	t.Parallel()
+
+	var cache history
+
+	binary := make([]byte, 256)
+
+	for idx := range binary {
+		binary[idx] = byte(idx)
+	}
+
+	wasm, _ := url.Parse("https://example.com/module.wasm")
+	text, _ := url.Parse("https://example.com/index.js")
+
+	cache.put(wasm, &reply{header: http.Header{"Content-Type": {"application/wasm"}}, body: binary})
+	cache.put(text, &reply{header: nil, body: []byte("Hello World!")})
+
+	var buff bytes.Buffer
+
+	assert.NoError(t, cache.marshal(&buff))
+
+	content := buff.String()
+
+	assert.Equal(t, 1, strings.Count(content, "Content-Transfer-Encoding: base64"))
+	assert.Contains(t, content, "Hello World!")
+
+	for _, line := range strings.Split(content, "\r\n") {
+		assert.LessOrEqual(t, len(line), 998)
+	}
+
+	var other history
+
+	assert.NoError(t, other.unmarshal(&buff))
+	assert.Equal(t, cache.store, other.store)
+
+	rep, _ := other.get(wasm)
+
+	assert.Equal(t, binary, rep.body)
+	assert.NotContains(t, rep.header, "Content-Transfer-Encoding")
+}
+
+func TestDecodeBody(t *testing.T) {
+	t.Parallel()
+
+	header, body, err := decodeBody(http.Header{"Content-Transfer-Encoding": {"BASE64"}}, []byte("SGVs\r\nbG8="))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello", string(body))
+	assert.Empty(t, header)
+
+	_, _, err = decodeBody(http.Header{"Content-Transfer-Encoding": {"quoted-printable"}}, nil)
+
+	assert.ErrorIs(t, err, errUnsupportedEncoding)
+
+	_, _, err = decodeBody(http.Header{"Content-Transfer-Encoding": {"base64"}}, []byte("!!!"))
+
+	assert.Error(t, err)
+}
+
+func TestHistory_marshalRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	bodies := []string{
+		"",
+		"a",
+		"a\r",
+		"a\n",
+		"a\r\n",
+		"\r\n",
+		"a\r\n\r\n",
+		" trailing ",
+		"--",
+		"a\r\n--",
+		"--" + cacheBoundary,
+		"x\n--" + cacheBoundary + "\ny",
+		"x\r\n--" + cacheBoundary + "--\r\n",
+		"x\r\n--" + cacheBoundary + "1\r\n",
+	}
+
+	var cache history
+
+	for idx, body := range bodies {
+		loc, _ := url.Parse("https://example.com/" + strconv.Itoa(idx))
+
+		cache.put(loc, &reply{header: nil, body: []byte(body)})
+	}
+
+	var buff bytes.Buffer
+
+	assert.NoError(t, cache.marshal(&buff))
+	assert.Contains(t, buff.String(), "boundary="+cacheBoundary+"2\r\n")
+
+	var other history
+
+	assert.NoError(t, other.unmarshal(&buff))
+
+	for idx, body := range bodies {
+		loc, _ := url.Parse("https://example.com/" + strconv.Itoa(idx))
+
+		rep, found := other.get(loc)
+
+		assert.True(t, found)
+		assert.Equal(t, body, string(rep.body))
+	}
+}
+
+func TestSafeBoundary(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, cacheBoundary, safeBoundary(nil))
+	assert.Equal(t, cacheBoundary, safeBoundary([][]byte{[]byte("foo")}))
+	assert.Equal(t, cacheBoundary+"1", safeBoundary([][]byte{[]byte(cacheBoundary)}))
+	assert.Equal(t, cacheBoundary+"2", safeBoundary([][]byte{[]byte(cacheBoundary), []byte(cacheBoundary + "1")}))
+}
+
+func TestHistory_unmarshalContentLength(t *testing.T) {
+	t.Parallel()
+
+	var cache history
+
+	loc, _ := url.Parse("https://example.com")
+
+	cache.put(loc, &reply{header: nil, body: []byte("Hello World!")})
+
+	var buff bytes.Buffer
+
+	assert.NoError(t, cache.marshal(&buff))
+
+	var other history
+
+	wrong := bytes.Replace(buff.Bytes(), []byte("Content-Length: 12"), []byte("Content-Length: 11"), 1)
+
+	assert.ErrorIs(t, other.unmarshal(bytes.NewReader(wrong)), errContentLength)
+
+	wrong = bytes.Replace(buff.Bytes(), []byte("Content-Length: 12"), []byte("Content-Length: foo"), 1)
+
+	assert.ErrorIs(t, other.unmarshal(bytes.NewReader(wrong)), errContentLength)
+}
+
+func TestHistory_unmarshalMissingContentLength(t *testing.T) {
+	t.Parallel()
+
+	var cache history
+
+	loc, _ := url.Parse("https://example.com")
+
+	cache.put(loc, &reply{header: nil, body: []byte("Hello World!")})
+
+	var buff bytes.Buffer
+
+	assert.NoError(t, cache.marshal(&buff))
+
+	var other history
+
+	wrong := bytes.Replace(buff.Bytes(), []byte("Content-Length: 12\r\n"), nil, 1)
+
+	assert.ErrorIs(t, other.unmarshal(bytes.NewReader(wrong)), errContentLength)
+}
+
+func TestHistory_unmarshalVersion(t *testing.T) {
+	t.Parallel()
+
+	var cache history
+
+	loc, _ := url.Parse("https://example.com")
+
+	cache.put(loc, &reply{header: nil, body: []byte("Hello World!")})
+
+	var buff bytes.Buffer
+
+	assert.NoError(t, cache.marshal(&buff))
+
+	var current history
+
+	assert.NoError(t, current.unmarshal(bytes.NewReader(buff.Bytes())))
+	assert.False(t, current.isOutdated())
+
+	var old history
+
+	v1 := bytes.Replace(buff.Bytes(), []byte("X-Xk6-Cache-Version: 4\r\n"), nil, 1)
+
+	assert.NoError(t, old.unmarshal(bytes.NewReader(v1)))
+	assert.True(t, old.isOutdated())
+
+	rep, found := old.get(loc)
+
+	assert.True(t, found)
+	assert.Equal(t, "Hello World!", string(rep.body))
+
+	var newer history
+
+	v4 := bytes.Replace(buff.Bytes(), []byte("X-Xk6-Cache-Version: 4"), []byte("X-Xk6-Cache-Version: 5"), 1)
+
+	assert.ErrorIs(t, newer.unmarshal(bytes.NewReader(v4)), errNewerFormatVersion)
+}
+
+func TestHistory_redact(t *testing.T) {
+	t.Parallel()
+
+	var cache history
+
+	loc, _ := url.Parse("https://example.com")
+
+	header := http.Header{}
+
+	header.Set(hdrContentType, "text/javascript")
+	header.Set("Set-Cookie", "session=secret")
+
+	cache.put(loc, &reply{header: header, body: []byte("Hello World!")})
+
+	cache.redact(defaultPolicy())
+
+	rep, _ := cache.get(loc)
+
+	assert.True(t, cache.isOutdated())
+	assert.Empty(t, rep.header.Get("Set-Cookie"))
+	assert.Equal(t, "text/javascript", rep.header.Get(hdrContentType))
+	assert.Equal(t, loc.String(), rep.header.Get(hdrContentLocation))
+}
+
+func TestHistory_canonical(t *testing.T) {
+	t.Parallel()
+
+	var cache history
+
+	loc, _ := url.Parse("HTTPS://Example.COM:443/index.js?b=2&a=1&_k6=1")
+
+	cache.put(loc, &reply{header: nil, body: []byte("Hello World!")})
+
+	for _, variant := range []string{
+		"https://example.com/index.js?_k6=1&a=1&b=2",
+		"https://example.com:443/index.js?a=1&_k6=1&b=2",
+		"https://EXAMPLE.com/index.js?b=2&_k6=1&a=1#sha384-foo",
+	} {
+		loc, _ := url.Parse(variant)
+
+		rep, found := cache.get(loc)
+
+		assert.True(t, found, variant)
+		assert.Equal(t, "https://example.com/index.js?_k6=1&a=1&b=2", rep.header.Get(hdrContentLocation))
+	}
+
+	var buff bytes.Buffer
+
+	assert.NoError(t, cache.marshal(&buff))
+
+	v2 := bytes.Replace(buff.Bytes(), []byte("X-Xk6-Cache-Version: 4"), []byte("X-Xk6-Cache-Version: 2"), 1)
+	v2 = bytes.Replace(v2, []byte("Content-Location: https://example.com/index.js?_k6=1&a=1&b=2"),
+		[]byte("Content-Location: HTTPS://example.com:443/index.js?b=2&a=1&_k6=1"), 1)
+
+	var old history
+
+	assert.NoError(t, old.unmarshal(bytes.NewReader(v2)))
+	assert.True(t, old.isOutdated())
+	assert.Equal(t, cache.keys(), old.keys())
+
+	var lazy history
+
+	assert.NoError(t, lazy.index(bytes.NewReader(v2), nil))
+	assert.True(t, lazy.isOutdated())
+	assert.Equal(t, cache.keys(), lazy.keys())
+
+	rep, found := lazy.get(loc)
+
+	assert.True(t, found)
+	assert.Equal(t, "Hello World!", string(rep.body))
+	assert.Nil(t, lazy.lazy)
+}
@@ -26,11 +26,11 @@ func cloneHeader(from http.Header) http.Header {
 	return header
 }
 
-func filterHeader(from http.Header) http.Header {
+func filterHeader(from http.Header, pol *policy) http.Header {
 	header := make(http.Header)
 
 	for key, value := range from {
-		if !strings.HasPrefix(key, "Content") && !strings.HasPrefix(key, "Access-Control") && key != "Set-Cookie" {
+		if !pol.persists(key) {
 			continue
 		}
 
@@ -75,7 +75,42 @@ func addK6QueryParam(loc *url.URL) {
 	loc.RawQuery = query
 }
 
+// canonicalURL returns the cache key form of loc: lowercase scheme and host, no default port,
+// sorted query parameters and no fragment.
+func canonicalURL(loc *url.URL) *url.URL {
+	canonical := *loc
+
+	canonical.Scheme = strings.ToLower(canonical.Scheme)
+	canonical.Host = strings.ToLower(canonical.Host)
+
+	if port := canonical.Port(); len(port) != 0 && defaultPorts[canonical.Scheme] == port {
+		canonical.Host = strings.TrimSuffix(canonical.Host, ":"+port)
+	}
+
+	canonical.Fragment, canonical.RawFragment = "", ""
+	canonical.ForceQuery = false
+
+	if len(canonical.RawQuery) != 0 {
+		if query, err := url.ParseQuery(canonical.RawQuery); err == nil {
+			canonical.RawQuery = query.Encode()
+		}
+	}
+
+	return &canonical
+}
+
+// canonicalKey returns the cache key of loc.
+func canonicalKey(loc *url.URL) string {
+	return canonicalURL(loc).String()
+}
+
+var defaultPorts = map[string]string{"http": "80", "https": "443"}
+
 const (
-	k6QueryVar    = "_k6"
-	k6QuerySuffix = k6QueryVar + "=1"
+	hdrETag            = "ETag"
+	hdrLastModified    = "Last-Modified"
+	hdrIfNoneMatch     = "If-None-Match"
+	hdrIfModifiedSince = "If-Modified-Since"
+	k6QueryVar         = "_k6"
+	k6QuerySuffix      = k6QueryVar + "=1"
 )
@@ -35,7 +35,7 @@ func TestFilterHeader(t *testing.T) {
 		"Access-Control-Allow-Origin": []string{"*"},
 	}
 
-	to := filterHeader(from) // nolint:varnamelen
+	to := filterHeader(from, defaultPolicy()) // nolint:varnamelen
 
 	assert.NotSame(t, from, to)
 	assert.NotEqual(t, from, to)
@@ -96,3 +96,33 @@ func TestAddK6QueryParam(t *testing.T) {
 	assert.Contains(t, loc.Query(), "_k6")
 	assert.Equal(t, loc.Query(), url.Values{"_k6": []string{"1"}, "foo": []string{"bar"}})
 }
+
+func TestCanonicalURL(t *testing.T) {
+	t.Parallel()
+
+	for from, to := range map[string]string{
+		"https://jslib.k6.io/x.js":                    "https://jslib.k6.io/x.js",
+		"HTTPS://JSLIB.k6.io:443/x.js":                "https://jslib.k6.io/x.js",
+		"http://example.com:80/x.js":                  "http://example.com/x.js",
+		"http://example.com:8080/x.js":                "http://example.com:8080/x.js",
+		"https://example.com:80/x.js":                 "https://example.com:80/x.js",
+		"https://example.com/x.js?":                   "https://example.com/x.js",
+		"https://example.com/x.js?b=2&a=1&_k6=1":      "https://example.com/x.js?_k6=1&a=1&b=2",
+		"https://example.com/x.js?a=2&a=1":            "https://example.com/x.js?a=2&a=1",
+		"https://example.com/x.js#sha384-abc":         "https://example.com/x.js",
+		"https://example.com/X.js":                    "https://example.com/X.js",
+		"https://example.com/x.js?_k6=1#fragment":     "https://example.com/x.js?_k6=1",
+		"https://example.com/x.js?broken=%zz&b=1&a=1": "https://example.com/x.js?broken=%zz&b=1&a=1",
+	} {
+		loc, err := url.Parse(from)
+
+		assert.NoError(t, err)
+		assert.Equal(t, to, canonicalKey(loc), from)
+	}
+
+	loc, _ := url.Parse("HTTPS://Example.com/x.js#foo")
+
+	canonicalURL(loc)
+
+	assert.Equal(t, "https://Example.com/x.js#foo", loc.String())
+}
@@ -0,0 +1,402 @@
+// SPDX-FileCopyrightText: 2023 Iván Szkiba
+//
+// SPDX-License-Identifier: MIT
+
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+)
+
+// indexEntry locates the body of a not yet loaded entry in the cache file.
+type indexEntry struct {
+	header http.Header
+	offset int64
+	size   int64
+}
+
+// indexed is the lazily loaded part of the history, read from an uncompressed email format file.
+type indexed struct {
+	entries map[string]*indexEntry
+	source  io.ReaderAt
+	closer  io.Closer
+	version int
+}
+
+// index reads the part headers of the email format cache file and records where the bodies are,
+// the bodies are loaded on demand. The source is closed when every entry has been loaded.
+func (c *history) index(source io.ReaderAt, closer io.Closer) error {
+	entries, version, err := scanIndex(io.NewSectionReader(source, 0, 1<<62))
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.lazy != nil {
+		return errAlreadyIndexed
+	}
+
+	c.describeLocked()
+
+	for key := range entries {
+		if _, found := c.store[key]; found {
+			delete(entries, key)
+		}
+	}
+
+	if version < formatVersion && len(entries) != 0 {
+		c.outdated = true
+	}
+
+	c.lazy = &indexed{entries: entries, source: source, closer: closer, version: version}
+
+	c.closeIndexLocked()
+
+	return nil
+}
+
+// loadLocked loads an indexed entry into the store. The caller holds the write lock.
+func (c *history) loadLocked(key string) (*reply, bool, error) {
+	if c.lazy == nil {
+		return nil, false, nil
+	}
+
+	entry, found := c.lazy.entries[key]
+	if !found {
+		return nil, false, nil
+	}
+
+	raw := make([]byte, entry.size)
+
+	if _, err := c.lazy.source.ReadAt(raw, entry.offset); err != nil {
+		return nil, false, fmt.Errorf("%w: %s", err, key)
+	}
+
+	rep := new(reply)
+
+	header, body, err := decodeBody(cloneHeader(entry.header), raw)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %s", err, key)
+	}
+
+	if strconv.Itoa(len(body)) != entry.header.Get(hdrContentLength) {
+		return nil, false, fmt.Errorf("%w: %s", errContentLength, key)
+	}
+
+	rep.header, rep.body = header, body
+
+	loc, err := prepare(rep, c.lazy.version)
+	if err != nil {
+		return nil, false, err
+	}
+
+	delete(c.lazy.entries, key)
+
+	c.putLocked(loc, rep)
+
+	return rep, true, nil
+}
+
+// materialize loads every indexed entry into the store.
+func (c *history) materialize() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.lazy == nil {
+		return nil
+	}
+
+	for key := range c.lazy.entries {
+		if _, _, err := c.loadLocked(key); err != nil {
+			return err
+		}
+
+		if c.lazy == nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+// closeIndexLocked closes the source of the index when every entry has been loaded.
+func (c *history) closeIndexLocked() {
+	if c.lazy == nil || len(c.lazy.entries) != 0 {
+		return
+	}
+
+	if c.lazy.closer != nil {
+		c.lazy.closer.Close() //nolint:errcheck,gosec
+	}
+
+	c.lazy = nil
+}
+
+// scanIndex returns the part headers and body positions of an email format cache file
+// without reading the bodies into memory. Bodies stored as is are skipped using their
+// Content-Length, only the encoded (or mismatching) ones are scanned for the delimiter.
+func scanIndex(source io.ReadSeeker) (map[string]*indexEntry, int, error) {
+	scan := &scanner{source: source, reader: bufio.NewReaderSize(source, scanBufferSize)}
+
+	head, err := scan.header()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var msg history
+
+	boundary, version, _, err := msg.unmarshalHeader(bytes.NewReader(head))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	delimiter := []byte("--" + boundary)
+	entries := make(map[string]*indexEntry)
+
+	// skip preamble
+	for {
+		line, _, err := scan.line()
+		if err != nil {
+			return nil, 0, unexpectedEOF(err)
+		}
+
+		if isDelimiter(line, delimiter) {
+			if isClosing(line, delimiter) {
+				return entries, version, nil
+			}
+
+			break
+		}
+	}
+
+	for {
+		block, err := scan.header()
+		if err != nil {
+			return nil, 0, unexpectedEOF(err)
+		}
+
+		mime, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(block))).ReadMIMEHeader()
+		if err != nil {
+			return nil, 0, err
+		}
+
+		header := http.Header(mime)
+		offset := scan.pos
+
+		end, closing, skipped, err := scan.skip(header, delimiter)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if !skipped {
+			if end, closing, err = scan.body(delimiter); err != nil {
+				return nil, 0, err
+			}
+		}
+
+		if loc := header.Get(hdrContentLocation); len(loc) != 0 {
+			if _, err := strconv.Atoi(header.Get(hdrContentLength)); err != nil {
+				return nil, 0, fmt.Errorf("%w: %s", errContentLength, loc)
+			}
+
+			key, err := url.Parse(loc)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			entries[canonicalKey(key)] = &indexEntry{header: header, offset: offset, size: end - offset}
+		}
+
+		if closing {
+			return entries, version, nil
+		}
+	}
+}
+
+// scanner reads lines keeping track of the position in the underlying reader.
+type scanner struct {
+	source  io.ReadSeeker
+	reader  *bufio.Reader
+	pos     int64
+	prevEOL int
+}
+
+// skip seeks past a body stored as is using its Content-Length. It returns the end of the body
+// and whether the delimiter following it is the closing one. If the body is encoded or it is not
+// followed by a delimiter, the position is not changed and the body is reported as not skipped.
+func (s *scanner) skip(header http.Header, delimiter []byte) (int64, bool, bool, error) {
+	size, err := strconv.ParseInt(header.Get(hdrContentLength), 10, 64)
+	if err != nil || size < 0 || len(header.Get(hdrContentTransferEncoding)) != 0 {
+		return 0, false, false, nil
+	}
+
+	offset := s.pos
+
+	if err := s.seek(offset + size); err != nil {
+		return 0, false, false, err
+	}
+
+	// the line break before the delimiter belongs to the delimiter
+	if line, eol, err := s.line(); err == nil && len(line) == 0 && eol != 0 {
+		if line, _, err := s.line(); err == nil && isDelimiter(line, delimiter) {
+			return offset + size, isClosing(line, delimiter), true, nil
+		}
+	}
+
+	return 0, false, false, s.seek(offset)
+}
+
+// body reads the lines of a body up to the delimiter. It returns the end of the body
+// and whether the delimiter is the closing one.
+func (s *scanner) body(delimiter []byte) (int64, bool, error) {
+	offset := s.pos
+
+	var end int64
+
+	var closing bool
+
+	for {
+		start := s.pos
+
+		line, eol, err := s.line()
+		if err != nil {
+			return 0, false, unexpectedEOF(err)
+		}
+
+		if isDelimiter(line, delimiter) {
+			end, closing = start, isClosing(line, delimiter)
+
+			break
+		}
+
+		s.prevEOL = eol
+	}
+
+	// the line break before the delimiter belongs to the delimiter
+	end -= int64(s.prevEOL)
+
+	if end < offset {
+		end = offset
+	}
+
+	s.prevEOL = 0
+
+	return end, closing, nil
+}
+
+// seek moves to the given position of the source.
+func (s *scanner) seek(pos int64) error {
+	if ahead := pos - s.pos; ahead >= 0 && ahead <= int64(s.reader.Buffered()) {
+		_, err := s.reader.Discard(int(ahead))
+		s.pos = pos
+
+		return err
+	}
+
+	if _, err := s.source.Seek(pos, io.SeekStart); err != nil {
+		return err
+	}
+
+	s.reader.Reset(s.source)
+	s.pos = pos
+
+	return nil
+}
+
+// line returns the beginning of the next line (long lines are truncated to the buffer size)
+// and the length of its line break.
+func (s *scanner) line() ([]byte, int, error) {
+	var head []byte
+
+	start := s.pos
+	truncated := false
+
+	for {
+		chunk, err := s.reader.ReadSlice('\n')
+
+		s.pos += int64(len(chunk))
+
+		if head == nil {
+			head = append([]byte(nil), chunk...)
+		}
+
+		if errors.Is(err, bufio.ErrBufferFull) {
+			truncated = true
+
+			continue
+		}
+
+		if err != nil && (s.pos == start || !errors.Is(err, io.EOF)) {
+			return nil, 0, err
+		}
+
+		eol := 0
+
+		if bytes.HasSuffix(chunk, []byte("\r\n")) {
+			eol = 2
+		} else if bytes.HasSuffix(chunk, []byte("\n")) {
+			eol = 1
+		}
+
+		if !truncated {
+			head = head[:len(head)-eol]
+		}
+
+		return head, eol, nil
+	}
+}
+
+// header returns the header block up to and including the empty line.
+func (s *scanner) header() ([]byte, error) {
+	var block bytes.Buffer
+
+	for {
+		line, eol, err := s.line()
+		if err != nil {
+			return nil, err
+		}
+
+		block.Write(line)
+		block.WriteString("\r\n")
+
+		if len(line) == 0 && eol != 0 {
+			return block.Bytes(), nil
+		}
+	}
+}
+
+func isDelimiter(line, delimiter []byte) bool {
+	if !bytes.HasPrefix(line, delimiter) {
+		return false
+	}
+
+	rest := bytes.TrimRight(line[len(delimiter):], " \t")
+
+	return len(rest) == 0 || bytes.Equal(rest, []byte("--"))
+}
+
+func isClosing(line, delimiter []byte) bool {
+	return bytes.Equal(bytes.TrimRight(line[len(delimiter):], " \t"), []byte("--"))
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+const scanBufferSize = 64 * 1024
+
+var errAlreadyIndexed = errors.New("cache file already indexed")
@@ -0,0 +1,258 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newIndexTestHistory(t *testing.T) *history {
+	t.Helper()
+
+	from := newTestHistory(t)
+
+	for key, body := range map[string][]byte{
+		"https://example.com/empty.js?_k6=1":    {},
+		"https://example.com/binary.wasm?_k6=1": {0, 'a', 's', 'm', 0xff, '\r', '\n'},
+		"https://example.com/crlf.js?_k6=1":     []byte("line\r\n\r\n"),
+		"https://example.com/boundary.js?_k6=1": []byte("// " + cacheBoundary + "\n--" + cacheBoundary),
+	} {
+		loc, _ := url.Parse(key)
+
+		from.put(loc, &reply{header: http.Header{}, body: body})
+	}
+
+	return from
+}
+
+func TestHistory_index(t *testing.T) {
+	t.Parallel()
+
+	from := newIndexTestHistory(t)
+
+	var buff bytes.Buffer
+
+	assert.NoError(t, from.marshal(&buff))
+
+	to := new(history)
+
+	assert.NoError(t, to.index(bytes.NewReader(buff.Bytes()), nil))
+	assert.Equal(t, from.keys(), to.keys())
+	assert.Len(t, to.store, 1)
+
+	for _, key := range from.keys() {
+		want, _ := from.lookup(key)
+
+		got, found, err := to.findKey(key)
+
+		assert.NoError(t, err, key)
+		assert.True(t, found, key)
+		assert.Equal(t, want.body, got.body, key)
+		assert.Equal(t, want.header, got.header, key)
+	}
+
+	assert.Nil(t, to.lazy)
+	assert.Equal(t, from.store, to.store)
+
+	assert.ErrorIs(t, new(history).index(strings.NewReader(""), nil), io.EOF)
+}
+
+func TestHistory_indexLF(t *testing.T) {
+	t.Parallel()
+
+	from := newTestHistory(t)
+
+	var buff bytes.Buffer
+
+	assert.NoError(t, from.marshal(&buff))
+
+	content := strings.ReplaceAll(buff.String(), "\r\n", "\n")
+
+	to := new(history)
+
+	assert.NoError(t, to.index(strings.NewReader(content), nil))
+	assert.NoError(t, to.materialize())
+	assert.Equal(t, from.store, to.store)
+}
+
+func TestHistory_indexErrors(t *testing.T) {
+	t.Parallel()
+
+	from := newTestHistory(t)
+
+	var buff bytes.Buffer
+
+	assert.NoError(t, from.marshal(&buff))
+
+	key := "https://jslib.k6.io/k6-utils/1.4.0/index.js?_k6=1"
+
+	tampered := bytes.Replace(buff.Bytes(), []byte("// https://jslib"), []byte("// HTTPS://jslib"), 1)
+
+	to := new(history)
+
+	assert.NoError(t, to.index(bytes.NewReader(tampered), nil))
+
+	_, _, err := to.findKey(key)
+
+	assert.ErrorIs(t, err, errIntegrityMismatch)
+	assert.ErrorIs(t, to.materialize(), errIntegrityMismatch)
+
+	_, found := to.lookup(key)
+
+	assert.False(t, found)
+
+	stripped := new(history)
+
+	assert.NoError(t, stripped.index(bytes.NewReader(bytes.ReplaceAll(tampered, []byte("Integrity:"), []byte("X-Foo:"))), nil))
+	assert.ErrorIs(t, stripped.materialize(), errMissingIntegrity)
+
+	truncated := buff.Bytes()[:buff.Len()-10]
+
+	assert.ErrorIs(t, new(history).index(bytes.NewReader(truncated), nil), io.ErrUnexpectedEOF)
+
+	missing := bytes.Replace(buff.Bytes(), []byte("Content-Length: 52\r\n"), nil, 1)
+
+	assert.ErrorIs(t, new(history).index(bytes.NewReader(missing), nil), errContentLength)
+
+	newer := bytes.Replace(buff.Bytes(), []byte("X-Xk6-Cache-Version: 4"), []byte("X-Xk6-Cache-Version: 5"), 1)
+
+	assert.ErrorIs(t, new(history).index(bytes.NewReader(newer), nil), errNewerFormatVersion)
+
+	twice := new(history)
+
+	assert.NoError(t, twice.index(bytes.NewReader(buff.Bytes()), nil))
+	assert.ErrorIs(t, twice.index(bytes.NewReader(buff.Bytes()), nil), errAlreadyIndexed)
+}
+
+func TestHistory_indexPut(t *testing.T) {
+	t.Parallel()
+
+	from := newTestHistory(t)
+
+	var buff bytes.Buffer
+
+	assert.NoError(t, from.marshal(&buff))
+
+	closer := new(testCloser)
+	to := new(history)
+
+	assert.NoError(t, to.index(bytes.NewReader(buff.Bytes()), closer))
+
+	for _, key := range from.keys() {
+		if len(key) == 0 {
+			continue
+		}
+
+		loc, _ := url.Parse(key)
+
+		to.put(loc, &reply{header: nil, body: []byte("override")})
+	}
+
+	assert.True(t, closer.closed)
+	assert.Nil(t, to.lazy)
+
+	for _, key := range from.keys()[1:] {
+		rep, _ := to.lookup(key)
+
+		assert.Equal(t, "override", string(rep.body))
+	}
+}
+
+func TestHistory_indexConcurrent(t *testing.T) {
+	t.Parallel()
+
+	from := newIndexTestHistory(t)
+
+	var buff bytes.Buffer
+
+	assert.NoError(t, from.marshal(&buff))
+
+	to := new(history)
+
+	assert.NoError(t, to.index(bytes.NewReader(buff.Bytes()), nil))
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for _, key := range from.keys() {
+				_, found := to.lookup(key)
+
+				assert.True(t, found, key)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+type testCloser struct {
+	closed bool
+}
+
+func (tc *testCloser) Close() error {
+	tc.closed = true
+
+	return nil
+}
+
+// countingReaderAt counts the bytes read from the underlying reader.
+type countingReaderAt struct {
+	reader io.ReaderAt
+	read   int64
+}
+
+func (cr *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := cr.reader.ReadAt(p, off)
+
+	cr.read += int64(n)
+
+	return n, err
+}
+
+func TestHistory_indexSkip(t *testing.T) {
+	t.Parallel()
+
+	from := newIndexTestHistory(t)
+
+	large := bytes.Repeat([]byte("// large module\r\n"), 1<<16)
+
+	loc, _ := url.Parse("https://example.com/large.js?_k6=1")
+
+	from.put(loc, &reply{header: http.Header{}, body: large})
+
+	var buff bytes.Buffer
+
+	assert.NoError(t, from.marshal(&buff))
+
+	source := &countingReaderAt{reader: bytes.NewReader(buff.Bytes()), read: 0}
+
+	to := new(history)
+
+	assert.NoError(t, to.index(source, nil))
+	assert.Less(t, source.read, int64(len(large)))
+
+	rep, found, err := to.findKey(canonicalKey(loc))
+
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, large, rep.body)
+
+	// a body not matching its Content-Length is scanned for the delimiter
+	grown := bytes.Replace(buff.Bytes(), []byte("// https://jslib"), []byte("// https://jslib.k6.io https://jslib"), 1)
+
+	to = new(history)
+
+	assert.NoError(t, to.index(bytes.NewReader(grown), nil))
+	assert.ErrorIs(t, to.materialize(), errContentLength)
+}
@@ -0,0 +1,116 @@
+// SPDX-FileCopyrightText: 2023 Iván Szkiba
+//
+// SPDX-License-Identifier: MIT
+
+package cache
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"strings"
+)
+
+// integrity returns the subresource integrity (SRI) style digest of body.
+func integrity(body []byte) string {
+	return integrityAlgorithm + "-" + digest(integrityAlgorithm, body)
+}
+
+func digest(algorithm string, body []byte) string {
+	var hasher hash.Hash
+
+	switch algorithm {
+	case "sha256":
+		hasher = sha256.New()
+	case "sha384":
+		hasher = sha512.New384()
+	case "sha512":
+		hasher = sha512.New()
+	default:
+		return ""
+	}
+
+	hasher.Write(body) //nolint:errcheck
+
+	return base64.StdEncoding.EncodeToString(hasher.Sum(nil))
+}
+
+// verifyIntegrity checks body against SRI metadata (space separated list of algorithm-digest pairs).
+// As the SRI specification requires, only the strongest algorithm's digests are considered.
+func verifyIntegrity(metadata string, body []byte) error {
+	strongest := -1
+	digests := []string{}
+
+	for _, item := range strings.Fields(metadata) {
+		item, _, _ = strings.Cut(item, "?") // options are ignored
+
+		algorithm, value, found := strings.Cut(item, "-")
+		if !found {
+			continue
+		}
+
+		rank := algorithmRank(algorithm)
+
+		switch {
+		case rank < 0 || rank < strongest:
+			continue
+		case rank > strongest:
+			strongest = rank
+			digests = digests[:0]
+		}
+
+		digests = append(digests, value)
+	}
+
+	if strongest < 0 {
+		return errInvalidIntegrity
+	}
+
+	actual := digest(integrityAlgorithms[strongest], body)
+
+	for _, expected := range digests {
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(actual)) == 1 {
+			return nil
+		}
+	}
+
+	return errIntegrityMismatch
+}
+
+// isIntegrity reports whether str contains SRI metadata with a supported algorithm.
+func isIntegrity(str string) bool {
+	for _, item := range strings.Fields(str) {
+		if algorithm, _, found := strings.Cut(item, "-"); found && algorithmRank(algorithm) >= 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func algorithmRank(algorithm string) int {
+	for idx, candidate := range integrityAlgorithms {
+		if strings.EqualFold(algorithm, candidate) {
+			return idx
+		}
+	}
+
+	return -1
+}
+
+const (
+	integrityAlgorithm = "sha384"
+	hdrIntegrity       = "Integrity"
+)
+
+// integrityAlgorithms lists the supported algorithms from the weakest to the strongest.
+var integrityAlgorithms = []string{"sha256", "sha384", "sha512"}
+
+var (
+	errInvalidIntegrity  = errors.New("no supported integrity digest")
+	errIntegrityMismatch = errors.New("integrity mismatch")
+	errMissingIntegrity  = errors.New("missing integrity digest")
+)
@@ -0,0 +1,38 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIntegrity(t *testing.T) {
+	t.Parallel()
+
+	body := []byte("alert('Hello, world.');")
+
+	// sample taken from the Subresource Integrity specification
+	assert.Equal(t, "sha384-H8BRh8j48O9oYatfu5AZzq6A9RINhZO5H16dQZngK7T62em8MUt1FLm52t+eX6xO", integrity(body))
+
+	assert.NoError(t, verifyIntegrity(integrity(body), body))
+	assert.NoError(t, verifyIntegrity("sha256-qznLcsROx4GACP2dm0UCKCzCG+HiZ1guq6ZZDob/Tng=", body))
+	assert.NoError(t, verifyIntegrity("sha256-invalid "+integrity(body), body))
+	assert.NoError(t, verifyIntegrity("md5-invalid "+integrity(body)+"?foo", body))
+	assert.NoError(t, verifyIntegrity("sha384-invalid "+integrity(body), body))
+
+	assert.ErrorIs(t, verifyIntegrity("sha512-invalid "+integrity(body), body), errIntegrityMismatch)
+	assert.ErrorIs(t, verifyIntegrity(integrity([]byte("other")), body), errIntegrityMismatch)
+	assert.ErrorIs(t, verifyIntegrity("", body), errInvalidIntegrity)
+	assert.ErrorIs(t, verifyIntegrity("md5-foo", body), errInvalidIntegrity)
+	assert.ErrorIs(t, verifyIntegrity("foo", body), errInvalidIntegrity)
+}
+
+func TestIsIntegrity(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, isIntegrity("sha384-foo"))
+	assert.True(t, isIntegrity("md5-foo sha256-bar"))
+	assert.False(t, isIntegrity(""))
+	assert.False(t, isIntegrity("md5-foo"))
+	assert.False(t, isIntegrity("section-2"))
+}
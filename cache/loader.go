@@ -0,0 +1,69 @@
+// SPDX-FileCopyrightText: 2023 Iván Szkiba
+//
+// SPDX-License-Identifier: MIT
+
+package cache
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// The k6 module loader fetches remote modules with a _k6=1 query parameter added, and retries
+// without it if the first request fails. The deprecated github.com/... and cdnjs.com/libraries/...
+// shortcuts are resolved by the loader itself: the former to a raw.githubusercontent.com URL,
+// the latter (after fetching the library metadata from api.cdnjs.com) to a cdnjs.cloudflare.com URL.
+// Entries are recorded with the _k6=1 parameter, lookups accept any of the forms,
+// so a cache recorded with one k6 version keeps working with another one.
+
+// loaderVariants returns the URLs the k6 loader may use for the module at loc, loc first.
+func loaderVariants(loc *url.URL) []*url.URL {
+	variant := *loc
+
+	if loc.Query().Has(k6QueryVar) {
+		removeK6QueryParam(&variant)
+	} else {
+		addK6QueryParam(&variant)
+	}
+
+	return []*url.URL{loc, &variant}
+}
+
+// removeK6QueryParam reverts addK6QueryParam.
+func removeK6QueryParam(loc *url.URL) {
+	query := loc.Query()
+
+	query.Del(k6QueryVar)
+
+	loc.RawQuery = query.Encode()
+}
+
+// find returns the cached entry of the module at loc, accepting any of the loader variants of loc.
+func (tw *tripperware) find(loc *url.URL) (*reply, bool, error) {
+	for _, variant := range loaderVariants(loc) {
+		rep, found, err := tw.history.find(variant)
+		if err != nil || found {
+			return rep, found, err
+		}
+	}
+
+	return nil, false, nil
+}
+
+// isLoaderMetadata reports whether the response belongs to a request made by the k6 loader
+// to resolve a module shortcut, which has to be cached regardless of its media type.
+func isLoaderMetadata(res *http.Response) bool {
+	if res.Request == nil || res.Request.URL == nil {
+		return false
+	}
+
+	loc := res.Request.URL
+
+	return strings.EqualFold(loc.Hostname(), cdnjsAPIHost) && strings.HasPrefix(loc.Path, cdnjsAPIPath)
+}
+
+const (
+	cdnjsAPIHost = "api.cdnjs.com"
+	cdnjsAPIPath = "/libraries/"
+)
@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoaderVariants(t *testing.T) {
+	t.Parallel()
+
+	for from, to := range map[string][]string{
+		"https://example.com/a.js":           {"https://example.com/a.js", "https://example.com/a.js?_k6=1"},
+		"https://example.com/a.js?_k6=1":     {"https://example.com/a.js?_k6=1", "https://example.com/a.js"},
+		"https://example.com/a.js?v=1":       {"https://example.com/a.js?v=1", "https://example.com/a.js?v=1&_k6=1"},
+		"https://example.com/a.js?v=1&_k6=1": {"https://example.com/a.js?v=1&_k6=1", "https://example.com/a.js?v=1"},
+	} {
+		loc, _ := url.Parse(from)
+
+		variants := []string{}
+
+		for _, variant := range loaderVariants(loc) {
+			variants = append(variants, variant.String())
+		}
+
+		assert.Equal(t, to, variants, from)
+	}
+}
+
+func TestTripperware_find(t *testing.T) {
+	t.Parallel()
+
+	tw := newTripperware(modeOffline, newTransport(t), logrus.StandardLogger())
+
+	for _, key := range []string{"https://example.com/a.js?_k6=1", "https://example.com/b.js"} {
+		loc, _ := url.Parse(key)
+
+		tw.history.put(loc, &reply{header: nil, body: []byte(key)})
+	}
+
+	for from, to := range map[string]string{
+		"https://example.com/a.js":       "https://example.com/a.js?_k6=1",
+		"https://example.com/a.js?_k6=1": "https://example.com/a.js?_k6=1",
+		"https://example.com/b.js":       "https://example.com/b.js",
+		"https://example.com/b.js?_k6=1": "https://example.com/b.js",
+	} {
+		loc, _ := url.Parse(from)
+
+		rep, found, err := tw.find(loc)
+
+		assert.NoError(t, err, from)
+		assert.True(t, found, from)
+		assert.Equal(t, to, string(rep.body), from)
+	}
+
+	loc, _ := url.Parse("https://example.com/c.js")
+
+	_, found, err := tw.find(loc)
+
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+type loaderTransport struct{}
+
+func (lt *loaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res := &http.Response{StatusCode: http.StatusOK, Request: req, Header: http.Header{}} //nolint:exhaustruct
+
+	if req.URL.Host == cdnjsAPIHost {
+		res.Header.Set(hdrContentType, "application/json")
+		res.Body = io.NopCloser(strings.NewReader(`{"name":"lodash.js","version":"4.17.21","filename":"lodash.min.js"}`))
+	} else {
+		res.Header.Set(hdrContentType, "application/javascript")
+		res.Body = io.NopCloser(strings.NewReader(req.URL.String()))
+	}
+
+	return res, nil
+}
+
+func TestTripperware_loaderShortcuts(t *testing.T) {
+	t.Parallel()
+
+	recorder := newTripperware(modeRecord, new(loaderTransport), logrus.StandardLogger())
+
+	// requests of the cdnjs.com/libraries/lodash.js and github.com/grafana/k6/samples/index.js shortcuts
+	urls := []string{
+		"https://api.cdnjs.com/libraries/lodash.js",
+		"https://cdnjs.cloudflare.com/ajax/libs/lodash.js/4.17.21/lodash.min.js?_k6=1",
+		"https://raw.githubusercontent.com/grafana/k6/master/samples/index.js?_k6=1",
+	}
+
+	get := func(tw *tripperware, loc string) string {
+		req := new(http.Request)
+		req.URL, _ = url.Parse(loc)
+
+		res, err := tw.RoundTrip(req)
+
+		assert.NoError(t, err, loc)
+
+		defer res.Body.Close() //nolint:errcheck
+
+		body, err := io.ReadAll(res.Body)
+
+		assert.NoError(t, err)
+
+		return string(body)
+	}
+
+	for _, loc := range urls {
+		get(recorder, loc)
+	}
+
+	offline := newTripperware(modeOffline, new(loaderTransport), logrus.StandardLogger())
+
+	offline.history = recorder.history
+
+	assert.Contains(t, get(offline, urls[0]), "lodash.min.js")
+	assert.Equal(t, urls[1], get(offline, urls[1]))
+	assert.Equal(t, urls[2], get(offline, strings.TrimSuffix(urls[2], "?_k6=1")))
+}
@@ -0,0 +1,35 @@
+// SPDX-FileCopyrightText: 2023 Iván Szkiba
+//
+// SPDX-License-Identifier: MIT
+
+package cache
+
+import "os"
+
+// lockFile acquires an advisory exclusive lock for filename, waiting for other holders.
+// The lock is held on a separate, persistent lock file next to filename,
+// because the cache file itself is replaced on save.
+func lockFile(filename string) (func() error, error) {
+	file, err := os.OpenFile(filename+lockSuffix, os.O_CREATE|os.O_RDWR, filePerm) //nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lock(file); err != nil {
+		file.Close() //nolint:errcheck,gosec
+
+		return nil, err
+	}
+
+	return func() error {
+		if err := unlock(file); err != nil {
+			file.Close() //nolint:errcheck,gosec
+
+			return err
+		}
+
+		return file.Close()
+	}, nil
+}
+
+const lockSuffix = ".lock"
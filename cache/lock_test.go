@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockFile(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), "vendor.eml")
+
+	unlock, err := lockFile(filename)
+
+	assert.NoError(t, err)
+	assert.FileExists(t, filename+lockSuffix)
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		events []string
+	)
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		unlock, err := lockFile(filename)
+
+		assert.NoError(t, err)
+
+		mu.Lock()
+		events = append(events, "second")
+		mu.Unlock()
+
+		assert.NoError(t, unlock())
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	mu.Lock()
+	events = append(events, "first")
+	mu.Unlock()
+
+	assert.NoError(t, unlock())
+
+	wg.Wait()
+
+	assert.Equal(t, []string{"first", "second"}, events)
+
+	_, err = lockFile(filepath.Join(filename, "missing", "vendor.eml"))
+
+	assert.Error(t, err)
+}
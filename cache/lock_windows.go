@@ -0,0 +1,27 @@
+// SPDX-FileCopyrightText: 2023 Iván Szkiba
+//
+// SPDX-License-Identifier: MIT
+
+//go:build windows
+
+package cache
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+const lockRange = ^uint32(0)
+
+func lock(file *os.File) error {
+	overlapped := new(windows.Overlapped)
+
+	return windows.LockFileEx(windows.Handle(file.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK, 0, lockRange, lockRange, overlapped)
+}
+
+func unlock(file *os.File) error {
+	overlapped := new(windows.Overlapped)
+
+	return windows.UnlockFileEx(windows.Handle(file.Fd()), 0, lockRange, lockRange, overlapped)
+}
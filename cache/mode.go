@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: 2023 Iván Szkiba
+//
+// SPDX-License-Identifier: MIT
+
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type mode string
+
+const (
+	// modeAuto records when the cache file is missing and replays otherwise.
+	modeAuto mode = "auto"
+	// modeRecord ignores the existing cache file and records every module from scratch.
+	modeRecord mode = "record"
+	// modeReplay serves modules from the cache file, misses are fetched but not recorded.
+	modeReplay mode = "replay"
+	// modeUpdate serves modules from the cache file and records the missing ones into it.
+	modeUpdate mode = "update"
+	// modeRefresh fetches every module again and updates the cache file.
+	modeRefresh mode = "refresh"
+	// modeTTL serves modules from the cache file while they are fresh according to
+	// the HTTP caching headers, expired modules are revalidated.
+	modeTTL mode = "ttl"
+	// modeOffline serves modules from the cache file, misses are reported as errors.
+	modeOffline mode = "offline"
+	// modePassthrough disables the cache, modules are always fetched.
+	modePassthrough mode = "passthrough"
+)
+
+var modes = []mode{modeAuto, modeRecord, modeReplay, modeUpdate, modeRefresh, modeTTL, modeOffline, modePassthrough}
+
+func parseMode(str string) (mode, error) {
+	if len(str) == 0 {
+		return modeAuto, nil
+	}
+
+	for _, candidate := range modes {
+		if strings.EqualFold(str, string(candidate)) {
+			return candidate, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s", errInvalidMode, str)
+}
+
+// loads reports whether the existing cache file should be read.
+func (m mode) loads() bool {
+	return m == modeReplay || m == modeUpdate || m == modeRefresh || m == modeTTL || m == modeOffline
+}
+
+// lookups reports whether requests should be served from the cache.
+func (m mode) lookups() bool {
+	return m == modeRecord || m == modeReplay || m == modeUpdate || m == modeOffline
+}
+
+// revalidates reports whether cached entries should be revalidated with conditional requests.
+func (m mode) revalidates() bool {
+	return m == modeRefresh || m == modeTTL
+}
+
+// fetches reports whether cache misses may be fetched from the network.
+func (m mode) fetches() bool {
+	return m != modeOffline
+}
+
+// records reports whether fetched modules should be stored in the cache.
+func (m mode) records() bool {
+	return m == modeRecord || m == modeUpdate || m == modeRefresh || m == modeTTL
+}
+
+// saves reports whether the cache file should be written on stop.
+func (m mode) saves() bool {
+	return m.records()
+}
+
+var errInvalidMode = errors.New("invalid cache mode")
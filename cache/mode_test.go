@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMode(t *testing.T) {
+	t.Parallel()
+
+	mod, err := parseMode("")
+
+	assert.NoError(t, err)
+	assert.Equal(t, modeAuto, mod)
+
+	for _, candidate := range modes {
+		mod, err = parseMode(string(candidate))
+
+		assert.NoError(t, err)
+		assert.Equal(t, candidate, mod)
+	}
+
+	mod, err = parseMode("Replay")
+
+	assert.NoError(t, err)
+	assert.Equal(t, modeReplay, mod)
+
+	_, err = parseMode("foo")
+
+	assert.ErrorIs(t, err, errInvalidMode)
+}
+
+func TestMode(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, modeRecord.lookups())
+	assert.True(t, modeRecord.records())
+	assert.True(t, modeRecord.saves())
+	assert.False(t, modeRecord.loads())
+
+	assert.True(t, modeReplay.loads())
+	assert.True(t, modeReplay.lookups())
+	assert.False(t, modeReplay.records())
+	assert.False(t, modeReplay.saves())
+
+	assert.True(t, modeUpdate.loads())
+	assert.True(t, modeUpdate.lookups())
+	assert.True(t, modeUpdate.records())
+	assert.True(t, modeUpdate.saves())
+
+	assert.True(t, modeRefresh.loads())
+	assert.False(t, modeRefresh.lookups())
+	assert.True(t, modeRefresh.records())
+	assert.True(t, modeRefresh.saves())
+
+	assert.True(t, modeTTL.loads())
+	assert.True(t, modeTTL.revalidates())
+	assert.False(t, modeTTL.lookups())
+	assert.True(t, modeTTL.records())
+	assert.True(t, modeTTL.saves())
+
+	assert.True(t, modeRefresh.revalidates())
+	assert.False(t, modeUpdate.revalidates())
+
+	assert.True(t, modeOffline.loads())
+	assert.True(t, modeOffline.lookups())
+	assert.False(t, modeOffline.fetches())
+	assert.False(t, modeOffline.records())
+	assert.False(t, modeOffline.saves())
+
+	assert.True(t, modeReplay.fetches())
+
+	assert.False(t, modePassthrough.loads())
+	assert.False(t, modePassthrough.lookups())
+	assert.False(t, modePassthrough.records())
+	assert.False(t, modePassthrough.saves())
+}
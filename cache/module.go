@@ -5,6 +5,8 @@
 package cache
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -23,55 +25,147 @@ const (
 )
 
 func New(params output.Params) (output.Output, error) {
+	cfg, err := parseConfigArgument(params.ConfigArgument)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := instance.check(cfg); err != nil {
+		return nil, err
+	}
+
 	return instance, nil
 }
 
-var envKey = "XK6_" + strings.ToUpper(moduleName)
+var (
+	envKey       = "XK6_" + strings.ToUpper(moduleName)
+	envModeKey   = envKey + "_MODE"
+	envConfigKey = envKey + "_CONFIG"
+	envSeedKey   = envKey + "_SEED"
+	envExportKey = envKey + "_EXPORT"
+)
 
 func init() { //nolint:gochecknoinits
-	file := os.Getenv(envKey)
-	instance = newModule(file, http.DefaultTransport, logrus.StandardLogger())
+	cfg, err := loadConfig(os.Getenv, os.Args[1:])
+	if err != nil {
+		panic(err)
+	}
 
-	if file == "" {
+	instance = newModule(cfg, http.DefaultTransport, logrus.StandardLogger())
+
+	if cfg.filename == "" {
 		return
 	}
 
 	http.DefaultTransport = instance
 
-	f, err := os.Open(file)
-	if err == nil {
-		if err := instance.tripperware.history.unmarshal(f); err != nil {
-			panic(err)
-		}
+	if err := instance.load(); err != nil {
+		panic(err)
+	}
+
+	if err := instance.seed(); err != nil {
+		panic(err)
 	}
 }
 
 type Module struct {
 	logger      logrus.FieldLogger
 	tripperware *tripperware
-	recording   bool
+	config      *config
+	mode        mode
 	filename    string
 }
 
-func newModule(filename string, transport http.RoundTripper, logger logrus.FieldLogger) *Module {
+func newModule(cfg *config, transport http.RoundTripper, logger logrus.FieldLogger) *Module {
 	module := new(Module)
 
 	module.logger = logger
+	module.config = cfg
 
-	if filename == "" {
+	if cfg.filename == "" {
 		return module
 	}
 
-	module.filename = filename
-	module.tripperware = newTripperware(transport, logger)
+	module.filename = cfg.filename
+
+	mod := cfg.mode
+
+	snapshot, err := newStorage(module.filename).stat()
+	if err != nil {
+		snapshot = nil
+	}
+
+	if mod == "" || mod == modeAuto {
+		if snapshot == nil {
+			mod = modeRecord
+		} else {
+			mod = modeReplay
+		}
+	}
+
+	module.mode = mod
+	module.tripperware = newTripperware(mod, transport, logger)
+	module.tripperware.filename = module.filename
+	module.tripperware.snapshot = snapshot
 
-	_, err := os.Stat(module.filename)
+	if cfg.policy != nil {
+		module.tripperware.policy = cfg.policy
+	}
 
-	module.recording = err != nil
+	module.tripperware.routes = cfg.routes
 
 	return module
 }
 
+// check verifies that the output argument matches the configuration detected at startup.
+func (m *Module) check(cfg *config) error {
+	if len(cfg.filename) != 0 && cfg.filename != m.config.filename {
+		return fmt.Errorf("%w: file %s instead of %s", errLateConfig, cfg.filename, m.config.filename)
+	}
+
+	if len(cfg.mode) != 0 && cfg.mode != m.config.mode {
+		return fmt.Errorf("%w: mode %s instead of %s", errLateConfig, cfg.mode, m.config.mode)
+	}
+
+	if len(cfg.seed) != 0 && cfg.seed != m.config.seed {
+		return fmt.Errorf("%w: seed %s instead of %s", errLateConfig, cfg.seed, m.config.seed)
+	}
+
+	if len(cfg.export) != 0 && cfg.export != m.config.export {
+		return fmt.Errorf("%w: export %s instead of %s", errLateConfig, cfg.export, m.config.export)
+	}
+
+	return nil
+}
+
+func (m *Module) load() error {
+	if !m.mode.loads() {
+		return nil
+	}
+
+	err := newStorage(m.filename).load(m.tripperware.history)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	m.tripperware.history.redact(m.tripperware.policy)
+
+	return nil
+}
+
+// seed records the configured HAR file into the cache.
+func (m *Module) seed() error {
+	if len(m.config.seed) == 0 {
+		return nil
+	}
+
+	return m.tripperware.seed(m.config.seed)
+}
+
 func (m *Module) Description() string {
 	return fmt.Sprintf("cache (%s)", m.filename)
 }
@@ -79,13 +173,41 @@ func (m *Module) Description() string {
 func (m *Module) Start() error { return nil }
 
 func (m *Module) Stop() error {
-	if !m.recording {
+	if err := m.save(); err != nil {
+		return err
+	}
+
+	return m.export()
+}
+
+// save writes the cache file if the mode saves and there is something to save.
+func (m *Module) save() error {
+	if !m.mode.saves() {
+		return nil
+	}
+
+	if m.mode == modeRefresh {
+		m.tripperware.refresh(context.Background())
+	}
+
+	if m.mode != modeRecord && m.tripperware.recorded.Load() == 0 && !m.tripperware.history.isOutdated() {
+		m.logger.Debug("no modules recorded")
+
 		return nil
 	}
 
 	return m.tripperware.save(m.filename)
 }
 
+// export writes the cache into the configured HAR file.
+func (m *Module) export() error {
+	if len(m.config.export) == 0 || m.tripperware == nil {
+		return nil
+	}
+
+	return m.tripperware.export(m.config.export)
+}
+
 func (m *Module) AddMetricSamples(_ []metrics.SampleContainer) {}
 
 func (m *Module) RoundTrip(req *http.Request) (*http.Response, error) {
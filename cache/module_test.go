@@ -1,20 +1,23 @@
 package cache
 
 import (
+	"bytes"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"go.k6.io/k6/output"
 )
 
 func TestNewModule(t *testing.T) {
 	t.Parallel()
 
 	transport := newTransport(t)
-	module := newModule("", transport, logrus.StandardLogger())
+	module := newModule(&config{filename: "", mode: modeAuto}, transport, logrus.StandardLogger())
 
 	assert.Nil(t, module.tripperware)
 	assert.NoError(t, module.Start())
@@ -27,7 +30,7 @@ func TestNewModule(t *testing.T) {
 	assert.NoError(t, file.Close())
 	assert.NoError(t, os.Remove(file.Name()))
 
-	module = newModule(file.Name(), transport, logrus.StandardLogger())
+	module = newModule(&config{filename: file.Name(), mode: modeAuto}, transport, logrus.StandardLogger())
 
 	assert.NotNil(t, module.tripperware)
 
@@ -42,7 +45,7 @@ func TestModule_oher(t *testing.T) {
 	t.Parallel()
 
 	transport := newTransport(t)
-	module := newModule("", transport, logrus.StandardLogger())
+	module := newModule(&config{filename: "", mode: modeAuto}, transport, logrus.StandardLogger())
 
 	assert.NotPanics(t, func() { module.AddMetricSamples(nil) })
 }
@@ -51,7 +54,7 @@ func TestModule_RoundTrip(t *testing.T) {
 	t.Parallel()
 
 	transport := newTransport(t)
-	module := newModule("foo", transport, logrus.StandardLogger())
+	module := newModule(&config{filename: "foo", mode: modeAuto}, transport, logrus.StandardLogger())
 
 	req := new(http.Request)
 
@@ -64,3 +67,297 @@ func TestModule_RoundTrip(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Same(t, req, res.Request)
 }
+
+func TestNewModule_mode(t *testing.T) {
+	t.Parallel()
+
+	transport := newTransport(t)
+
+	file, err := os.CreateTemp("", "")
+
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	defer os.Remove(file.Name()) //nolint:errcheck
+
+	module := newModule(&config{filename: file.Name(), mode: modeAuto}, transport, logrus.StandardLogger())
+
+	assert.Equal(t, modeReplay, module.mode)
+	assert.Equal(t, modeReplay, module.tripperware.mode)
+
+	module = newModule(&config{filename: file.Name() + ".missing", mode: modeAuto}, transport, logrus.StandardLogger())
+
+	assert.Equal(t, modeRecord, module.mode)
+
+	module = newModule(&config{filename: file.Name(), mode: modeRecord}, transport, logrus.StandardLogger())
+
+	assert.Equal(t, modeRecord, module.mode)
+}
+
+func TestModule_load(t *testing.T) {
+	t.Parallel()
+
+	transport := newTransport(t)
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "vendor.eml")
+
+	module := newModule(&config{filename: filename, mode: modeRecord}, transport, logrus.StandardLogger())
+
+	req := new(http.Request)
+	req.URL, _ = url.Parse("https://example.com?_k6=1")
+
+	_, err := module.RoundTrip(req) // nolint:bodyclose
+
+	assert.NoError(t, err)
+	assert.NoError(t, module.Stop())
+
+	module = newModule(&config{filename: filename, mode: modeReplay}, transport, logrus.StandardLogger())
+
+	assert.NoError(t, module.load())
+
+	_, found := module.tripperware.history.get(req.URL)
+
+	assert.True(t, found)
+
+	module = newModule(&config{filename: filename, mode: modeRecord}, transport, logrus.StandardLogger())
+
+	assert.NoError(t, module.load())
+	assert.Empty(t, module.tripperware.history.store)
+
+	module = newModule(&config{filename: filepath.Join(dir, "missing.eml"), mode: modeReplay}, transport, logrus.StandardLogger())
+
+	assert.NoError(t, module.load())
+
+	assert.NoError(t, os.WriteFile(filename, []byte("garbage"), 0o600))
+
+	module = newModule(&config{filename: filename, mode: modeReplay}, transport, logrus.StandardLogger())
+
+	assert.Error(t, module.load())
+}
+
+func TestModule_update(t *testing.T) {
+	t.Parallel()
+
+	transport := newTransport(t)
+	filename := filepath.Join(t.TempDir(), "vendor.eml")
+
+	get := func(module *Module, loc string) {
+		req := new(http.Request)
+		req.URL, _ = url.Parse(loc)
+
+		res, err := module.RoundTrip(req)
+
+		assert.NoError(t, err)
+		assert.NoError(t, res.Body.Close())
+	}
+
+	module := newModule(&config{filename: filename, mode: modeRecord}, transport, logrus.StandardLogger())
+
+	get(module, "https://example.net/b.js?_k6=1")
+
+	assert.NoError(t, module.Stop())
+
+	info, err := os.Stat(filename)
+
+	assert.NoError(t, err)
+
+	module = newModule(&config{filename: filename, mode: modeUpdate}, transport, logrus.StandardLogger())
+
+	assert.NoError(t, module.load())
+
+	get(module, "https://example.net/b.js?_k6=1")
+
+	assert.NoError(t, module.Stop())
+
+	same, err := os.Stat(filename)
+
+	assert.NoError(t, err)
+	assert.Equal(t, info.ModTime(), same.ModTime())
+
+	module = newModule(&config{filename: filename, mode: modeUpdate}, transport, logrus.StandardLogger())
+
+	assert.NoError(t, module.load())
+
+	get(module, "https://example.com/a.js?_k6=1")
+
+	assert.NoError(t, module.Stop())
+
+	content, err := os.ReadFile(filename)
+
+	assert.NoError(t, err)
+
+	comIndex := bytes.Index(content, []byte("Content-Location: https://example.com/a.js"))
+	netIndex := bytes.Index(content, []byte("Content-Location: https://example.net/b.js"))
+
+	assert.Greater(t, comIndex, 0)
+	assert.Greater(t, netIndex, 0)
+	assert.Less(t, comIndex, netIndex)
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	out, err := New(output.Params{ConfigArgument: ""}) //nolint:exhaustruct
+
+	assert.NoError(t, err)
+	assert.Same(t, instance, out)
+
+	_, err = New(output.Params{ConfigArgument: "mode=foo"}) //nolint:exhaustruct
+
+	assert.Error(t, err)
+}
+
+func TestModule_check(t *testing.T) {
+	t.Parallel()
+
+	transport := newTransport(t)
+	module := newModule(&config{filename: "vendor.eml", mode: modeOffline}, transport, logrus.StandardLogger())
+
+	assert.NoError(t, module.check(&config{}))
+	assert.NoError(t, module.check(&config{filename: "vendor.eml", mode: modeOffline}))
+	assert.ErrorIs(t, module.check(&config{filename: "other.eml"}), errLateConfig)
+	assert.ErrorIs(t, module.check(&config{mode: modeRecord}), errLateConfig)
+}
+
+func TestModule_concurrentRecording(t *testing.T) {
+	t.Parallel()
+
+	transport := newTransport(t)
+	filename := filepath.Join(t.TempDir(), "vendor.eml")
+
+	get := func(module *Module, loc string) {
+		req := new(http.Request)
+		req.URL, _ = url.Parse(loc)
+
+		res, err := module.RoundTrip(req)
+
+		assert.NoError(t, err)
+		assert.NoError(t, res.Body.Close())
+	}
+
+	first := newModule(&config{filename: filename, mode: modeAuto}, transport, logrus.StandardLogger())
+	second := newModule(&config{filename: filename, mode: modeAuto}, transport, logrus.StandardLogger())
+
+	get(first, "https://example.com/a.js?_k6=1")
+	get(second, "https://example.com/b.js?_k6=1")
+
+	assert.NoError(t, first.Stop())
+	assert.NoError(t, second.Stop())
+
+	module := newModule(&config{filename: filename, mode: modeOffline}, transport, logrus.StandardLogger())
+
+	assert.NoError(t, module.load())
+
+	get(module, "https://example.com/a.js?_k6=1")
+	get(module, "https://example.com/b.js?_k6=1")
+
+	third := newModule(&config{filename: filename, mode: modeRecord}, transport, logrus.StandardLogger())
+
+	get(third, "https://example.com/c.js?_k6=1")
+
+	assert.NoError(t, third.Stop())
+
+	module = newModule(&config{filename: filename, mode: modeReplay}, transport, logrus.StandardLogger())
+
+	assert.NoError(t, module.load())
+	assert.Len(t, module.tripperware.history.keys(), 2)
+}
+
+func TestModule_directory(t *testing.T) {
+	t.Parallel()
+
+	transport := newTransport(t)
+	dirname := filepath.Join(t.TempDir(), "vendor") + "/"
+
+	module := newModule(&config{filename: dirname, mode: modeAuto}, transport, logrus.StandardLogger())
+
+	assert.Equal(t, modeRecord, module.mode)
+
+	req := new(http.Request)
+	req.URL, _ = url.Parse("https://example.com/index.js?_k6=1")
+
+	res, err := module.RoundTrip(req)
+
+	assert.NoError(t, err)
+	assert.NoError(t, res.Body.Close())
+	assert.NoError(t, module.Stop())
+	assert.FileExists(t, filepath.Join(dirname, "example.com", "index.js"))
+
+	module = newModule(&config{filename: dirname, mode: modeAuto}, transport, logrus.StandardLogger())
+
+	assert.Equal(t, modeReplay, module.mode)
+	assert.NoError(t, module.load())
+
+	_, found := module.tripperware.history.get(req.URL)
+
+	assert.True(t, found)
+}
+
+func TestModule_upgrade(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), "vendor.eml")
+
+	var old history
+
+	loc, _ := url.Parse("https://example.net/b.js?_k6=1")
+
+	old.put(loc, &reply{header: nil, body: []byte("Hello World!")})
+
+	var buff bytes.Buffer
+
+	assert.NoError(t, old.marshal(&buff))
+
+	v1 := bytes.Replace(buff.Bytes(), []byte("X-Xk6-Cache-Version: 4\r\n"), nil, 1)
+
+	assert.NoError(t, os.WriteFile(filename, v1, 0o600))
+
+	module := newModule(&config{filename: filename, mode: modeUpdate}, newTransport(t), logrus.StandardLogger())
+
+	assert.NoError(t, module.load())
+	assert.NoError(t, module.Stop())
+
+	content, err := os.ReadFile(filename)
+
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "X-Xk6-Cache-Version: 4\r\n")
+}
+
+func TestModule_export(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "vendor.eml")
+	export := filepath.Join(dir, "vendor.har")
+
+	module := newModule(&config{filename: filename, mode: modeRecord}, newTransport(t), logrus.StandardLogger())
+
+	req := new(http.Request)
+	req.URL, _ = url.Parse("https://example.com/a.js?_k6=1")
+
+	res, err := module.RoundTrip(req)
+
+	assert.NoError(t, err)
+	assert.NoError(t, res.Body.Close())
+	assert.NoError(t, module.Stop())
+	assert.NoFileExists(t, export)
+
+	module = newModule(&config{filename: filename, mode: modeReplay, export: export}, newTransport(t), logrus.StandardLogger())
+
+	assert.NoError(t, module.load())
+	assert.NoError(t, module.Stop())
+
+	file, err := os.Open(export) //nolint:gosec
+
+	assert.NoError(t, err)
+
+	defer file.Close() //nolint:errcheck
+
+	doc, err := readHAR(file)
+
+	assert.NoError(t, err)
+	assert.Len(t, doc.Log.Entries, 1)
+	assert.Equal(t, "https://example.com/a.js?_k6=1", doc.Log.Entries[0].Request.URL)
+	assert.Equal(t, "Hello World!", doc.Log.Entries[0].Response.Content.Text)
+}
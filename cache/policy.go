@@ -0,0 +1,107 @@
+// SPDX-FileCopyrightText: 2023 Iván Szkiba
+//
+// SPDX-License-Identifier: MIT
+
+package cache
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// policy decides what gets into the cache. Every setting is a list of case-insensitive
+// wildcard patterns, where '*' matches any sequence of characters.
+type policy struct {
+	// allowHosts lists the cacheable hosts, empty list means every host.
+	allowHosts []string
+	// denyHosts lists the hosts that bypass the cache.
+	denyHosts []string
+	// headers lists the response headers persisted into the cache file.
+	headers []string
+	// denyHeaders lists the response headers never persisted, it takes precedence over headers.
+	denyHeaders []string
+	// contentTypes lists the media types stored into the cache file.
+	contentTypes []string
+}
+
+func defaultPolicy() *policy {
+	return &policy{
+		allowHosts:   nil,
+		denyHosts:    nil,
+		headers:      []string{"Content*", "Access-Control*", hdrETag, hdrLastModified},
+		denyHeaders:  sensitiveHeaders(),
+		contentTypes: []string{"text*", "*javascript*", "application/wasm", "*protobuf*", "image/*"},
+	}
+}
+
+// sensitiveHeaders returns the patterns of the headers which may carry secrets.
+func sensitiveHeaders() []string {
+	return []string{"Set-Cookie*", "Cookie", "*Authorization", "*Authenticate", "*Token*", "*Secret*", "*Api-Key*", "*Session*"}
+}
+
+// cacheable reports whether requests to loc may be served from and recorded into the cache.
+func (p *policy) cacheable(loc *url.URL) bool {
+	host := loc.Hostname()
+
+	if matchAny(p.denyHosts, host) {
+		return false
+	}
+
+	return len(p.allowHosts) == 0 || matchAny(p.allowHosts, host)
+}
+
+// persists reports whether a response header should be stored.
+func (p *policy) persists(header string) bool {
+	return !p.redacts(header) && matchAny(p.headers, header)
+}
+
+// redacts reports whether a response header is denied. The headers maintained by the cache itself
+// are never denied.
+func (p *policy) redacts(header string) bool {
+	switch http.CanonicalHeaderKey(header) {
+	case hdrContentLocation, hdrContentLength, hdrContentDisposition, hdrIntegrity, hdrStatus, hdrLocation:
+		return false
+	}
+
+	return matchAny(p.denyHeaders, header)
+}
+
+// storable reports whether a response having mediatype should be stored.
+func (p *policy) storable(mediatype string) bool {
+	return matchAny(p.contentTypes, mediatype)
+}
+
+func matchAny(patterns []string, str string) bool {
+	str = strings.ToLower(str)
+
+	for _, pattern := range patterns {
+		if wildcard(strings.ToLower(pattern), str) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func wildcard(pattern, str string) bool {
+	for len(pattern) != 0 {
+		if pattern[0] == '*' {
+			for idx := 0; idx <= len(str); idx++ {
+				if wildcard(pattern[1:], str[idx:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(str) == 0 || pattern[0] != str[0] {
+			return false
+		}
+
+		pattern, str = pattern[1:], str[1:]
+	}
+
+	return len(str) == 0
+}
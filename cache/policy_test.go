@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWildcard(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, wildcard("", ""))
+	assert.True(t, wildcard("*", ""))
+	assert.True(t, wildcard("*", "foo"))
+	assert.True(t, wildcard("foo", "foo"))
+	assert.True(t, wildcard("foo*", "foobar"))
+	assert.True(t, wildcard("*bar", "foobar"))
+	assert.True(t, wildcard("*javascript*", "application/javascript"))
+	assert.True(t, wildcard("*.k6.io", "jslib.k6.io"))
+	assert.False(t, wildcard("foo", "foobar"))
+	assert.False(t, wildcard("*.k6.io", "k6.io"))
+	assert.False(t, wildcard("foo*", "bar"))
+}
+
+func TestPolicy(t *testing.T) {
+	t.Parallel()
+
+	pol := defaultPolicy()
+
+	loc, _ := url.Parse("https://jslib.k6.io/k6-utils/1.4.0/index.js")
+
+	assert.True(t, pol.cacheable(loc))
+	assert.True(t, pol.persists("Content-Type"))
+	assert.True(t, pol.persists("access-control-allow-origin"))
+	assert.False(t, pol.persists("Location"))
+	assert.False(t, pol.persists("Set-Cookie"))
+	assert.False(t, pol.persists("Content-Security-Token"))
+	assert.True(t, pol.redacts("Authorization"))
+	assert.True(t, pol.redacts("www-authenticate"))
+	assert.True(t, pol.redacts("X-Auth-Token"))
+	assert.True(t, pol.redacts("X-Api-Key"))
+	assert.False(t, pol.redacts("Content-Type"))
+	assert.True(t, pol.storable("text/plain"))
+	assert.True(t, pol.storable("application/javascript"))
+	assert.False(t, pol.storable("application/octet-stream"))
+
+	pol.allowHosts = []string{"*.k6.io"}
+
+	assert.True(t, pol.cacheable(loc))
+
+	pol.denyHosts = []string{"jslib.k6.io"}
+
+	assert.False(t, pol.cacheable(loc))
+
+	pol.denyHosts = nil
+	loc, _ = url.Parse("https://example.com/index.js")
+
+	assert.False(t, pol.cacheable(loc))
+}
+
+func TestPolicy_redacts(t *testing.T) {
+	t.Parallel()
+
+	pol := defaultPolicy()
+
+	pol.headers = []string{"*"}
+	pol.denyHeaders = []string{"*"}
+
+	for _, header := range []string{hdrContentLocation, hdrContentLength, hdrContentDisposition, hdrIntegrity} {
+		assert.False(t, pol.redacts(header))
+		assert.True(t, pol.persists(header))
+	}
+
+	assert.False(t, pol.persists("Content-Type"))
+
+	pol.denyHeaders = nil
+
+	assert.True(t, pol.persists("Set-Cookie"))
+}
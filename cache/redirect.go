@@ -0,0 +1,48 @@
+// SPDX-FileCopyrightText: 2023 Iván Szkiba
+//
+// SPDX-License-Identifier: MIT
+
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Redirects are stored as entries having a status other than 200 in the status header.
+// The HTTP client follows the replayed redirects, so a redirect chain resolves entirely
+// from the cache.
+
+// isRedirect reports whether a response having the status code and header is a storable redirect.
+func isRedirect(code int, header http.Header) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return len(header.Get(hdrLocation)) != 0
+	default:
+		return false
+	}
+}
+
+// statusOf returns the HTTP status code stored in the header of an entry, zero if there is none.
+func statusOf(header http.Header) (int, error) {
+	str := header.Get(hdrStatus)
+	if len(str) == 0 {
+		return 0, nil
+	}
+
+	code, err := strconv.Atoi(str)
+	if err != nil || !isRedirect(code, header) {
+		return 0, fmt.Errorf("%w: %s", errInvalidStatus, str)
+	}
+
+	return code, nil
+}
+
+const (
+	hdrStatus   = "X-Xk6-Cache-Status"
+	hdrLocation = "Location"
+)
+
+var errInvalidStatus = errors.New("invalid cache entry status")
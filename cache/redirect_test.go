@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRedirect(t *testing.T) {
+	t.Parallel()
+
+	location := http.Header{hdrLocation: {"/b.js"}}
+
+	for _, code := range []int{http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect} {
+		assert.True(t, isRedirect(code, location), code)
+		assert.False(t, isRedirect(code, http.Header{}), code)
+	}
+
+	assert.False(t, isRedirect(http.StatusOK, location))
+	assert.False(t, isRedirect(http.StatusSeeOther, location))
+	assert.False(t, isRedirect(http.StatusNotModified, location))
+}
+
+func TestStatusOf(t *testing.T) {
+	t.Parallel()
+
+	code, err := statusOf(http.Header{})
+
+	assert.NoError(t, err)
+	assert.Zero(t, code)
+
+	code, err = statusOf(http.Header{hdrStatus: {"302"}, hdrLocation: {"/b.js"}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusFound, code)
+
+	_, err = statusOf(http.Header{hdrStatus: {"302"}})
+
+	assert.ErrorIs(t, err, errInvalidStatus)
+
+	_, err = statusOf(http.Header{hdrStatus: {"foo"}, hdrLocation: {"/b.js"}})
+
+	assert.ErrorIs(t, err, errInvalidStatus)
+}
+
+// redirectTransport redirects /lodash to /lodash@4.17.21/lodash.js and serves everything else.
+type redirectTransport struct {
+	requests int
+}
+
+func (rt *redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.requests++
+
+	res := &http.Response{StatusCode: http.StatusOK, Request: req, Header: http.Header{}} //nolint:exhaustruct
+
+	if req.URL.Path == "/lodash" {
+		res.StatusCode = http.StatusFound
+		res.Header.Set(hdrLocation, "/lodash@4.17.21/lodash.js")
+		res.Header.Set(hdrContentType, "text/plain")
+		res.Body = io.NopCloser(strings.NewReader("Found. Redirecting to /lodash@4.17.21/lodash.js"))
+
+		return res, nil
+	}
+
+	res.Header.Set(hdrContentType, "application/javascript")
+	res.Body = io.NopCloser(strings.NewReader("// " + req.URL.Path))
+
+	return res, nil
+}
+
+func TestTripperware_redirect(t *testing.T) {
+	t.Parallel()
+
+	get := func(tw *tripperware) string {
+		client := &http.Client{Transport: tw} //nolint:exhaustruct
+
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "https://unpkg.com/lodash?_k6=1", nil)
+
+		assert.NoError(t, err)
+
+		res, err := client.Do(req)
+
+		assert.NoError(t, err)
+
+		defer res.Body.Close() //nolint:errcheck
+
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+
+		body, err := io.ReadAll(res.Body)
+
+		assert.NoError(t, err)
+
+		return string(body)
+	}
+
+	transport := new(redirectTransport)
+	recorder := newTripperware(modeRecord, transport, logrus.StandardLogger())
+
+	assert.Equal(t, "// /lodash@4.17.21/lodash.js", get(recorder))
+	assert.Equal(t, 2, transport.requests)
+
+	loc, _ := url.Parse("https://unpkg.com/lodash?_k6=1")
+
+	rep, found := recorder.history.get(loc)
+
+	assert.True(t, found)
+	assert.Equal(t, http.StatusFound, rep.status)
+	assert.Equal(t, "/lodash@4.17.21/lodash.js", rep.header.Get(hdrLocation))
+	assert.Equal(t, "302", rep.header.Get(hdrStatus))
+
+	res := reply2response(new(http.Request), rep)
+
+	assert.NoError(t, res.Body.Close())
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	assert.Empty(t, res.Header.Get(hdrStatus))
+
+	var buff bytes.Buffer
+
+	assert.NoError(t, recorder.history.marshal(&buff))
+
+	offline := newTripperware(modeOffline, transport, logrus.StandardLogger())
+
+	assert.NoError(t, offline.history.index(bytes.NewReader(buff.Bytes()), nil))
+	assert.Equal(t, "// /lodash@4.17.21/lodash.js", get(offline))
+	assert.Equal(t, 2, transport.requests)
+
+	doc, err := recorder.history.exportHAR()
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusFound, doc.Log.Entries[0].Response.Status)
+	assert.Equal(t, "/lodash@4.17.21/lodash.js", doc.Log.Entries[0].Response.RedirectURL)
+
+	dir := filepath.Join(t.TempDir(), "vendor") + "/"
+
+	assert.NoError(t, newStorage(dir).save(recorder.history))
+
+	restored := new(history)
+
+	assert.NoError(t, newStorage(dir).load(restored))
+
+	rep, found = restored.get(loc)
+
+	assert.True(t, found)
+	assert.Equal(t, http.StatusFound, rep.status)
+}
@@ -0,0 +1,161 @@
+// SPDX-FileCopyrightText: 2023 Iván Szkiba
+//
+// SPDX-License-Identifier: MIT
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// revalidate sends a conditional request for a cached entry. Unchanged entries are served
+// from the cache, changed ones are replaced in the history if they match the integrity metadata.
+func (tw *tripperware) revalidate(req *http.Request, rep *reply, metadata string) (*http.Response, error) {
+	log := tw.logger.WithField("url", req.URL.String())
+
+	tw.revalidated.Store(rep.header.Get(hdrContentLocation), true)
+
+	cond := req.Clone(req.Context())
+
+	if cond.Header == nil {
+		cond.Header = http.Header{}
+	}
+
+	cond.Header.Del(hdrAcceptEncoding) // avoid compressed response
+
+	if etag := rep.header.Get(hdrETag); len(etag) != 0 {
+		cond.Header.Set(hdrIfNoneMatch, etag)
+	}
+
+	if modified := rep.header.Get(hdrLastModified); len(modified) != 0 {
+		cond.Header.Set(hdrIfModifiedSince, modified)
+	}
+
+	res, err := tw.fetch(cond)
+	if err != nil {
+		log.WithError(err).Warn("module revalidation failed, keeping cached version")
+
+		return tw.verified(req, reply2response(req, rep), metadata)
+	}
+
+	if res.StatusCode == http.StatusNotModified {
+		res.Body.Close() //nolint:errcheck,gosec
+
+		log.Debug("module not modified")
+
+		if tw.mode == modeTTL {
+			rep = tw.renew(rep, res.Header)
+		}
+
+		return tw.verified(req, reply2response(req, rep), metadata)
+	}
+
+	if res.StatusCode >= http.StatusBadRequest {
+		res.Body.Close() //nolint:errcheck,gosec
+
+		log.WithField("status", res.StatusCode).Warn("module revalidation failed, keeping cached version")
+
+		return tw.verified(req, reply2response(req, rep), metadata)
+	}
+
+	if !tw.shouldStore(res) {
+		if tw.mode == modeTTL && noStore(res.Header) {
+			log.Debug("module no longer stored")
+
+			tw.history.remove(rep.header.Get(hdrContentLocation))
+			tw.recorded.Add(1)
+		}
+
+		return tw.verified(req, res, metadata)
+	}
+
+	res, err = tw.verified(req, res, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := duplicateBody(res)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(body, rep.body) || res.StatusCode != rep.code() ||
+		res.Header.Get(hdrLocation) != rep.header.Get(hdrLocation) {
+		log.Info("module changed")
+	} else if tw.mode != modeTTL {
+		log.Debug("module not changed")
+
+		return res, nil
+	}
+
+	return tw.record(req, res)
+}
+
+// renew replaces a revalidated entry with a fresh copy, using the caching headers of the
+// not modified response.
+func (tw *tripperware) renew(rep *reply, from http.Header) *reply {
+	loc, err := url.Parse(rep.header.Get(hdrContentLocation))
+	if err != nil {
+		return rep
+	}
+
+	renewed := &reply{header: cloneHeader(rep.header), body: rep.body}
+
+	tw.stamp(renewed.header, from)
+	tw.history.put(loc, renewed)
+	tw.recorded.Add(1)
+
+	return renewed
+}
+
+// refresh revalidates the cached entries not requested during the run.
+func (tw *tripperware) refresh(ctx context.Context) {
+	for _, key := range tw.history.keys() {
+		if len(key) == 0 {
+			continue
+		}
+
+		if _, done := tw.revalidated.Load(key); done {
+			continue
+		}
+
+		log := tw.logger.WithField("url", key)
+
+		loc, err := url.Parse(key)
+		if err != nil {
+			log.WithError(err).Warn("invalid cache key")
+
+			continue
+		}
+
+		rep, ok, err := tw.history.find(loc)
+		if err != nil {
+			log.WithError(err).Warn("module revalidation failed, keeping cached version")
+
+			continue
+		}
+
+		if !ok {
+			continue
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, key, nil)
+		if err != nil {
+			log.WithError(err).Warn("module revalidation failed, keeping cached version")
+
+			continue
+		}
+
+		res, err := tw.revalidate(req, rep, "")
+		if err != nil {
+			log.WithError(err).Warn("module revalidation failed, keeping cached version")
+
+			continue
+		}
+
+		res.Body.Close() //nolint:errcheck,gosec
+	}
+}
@@ -0,0 +1,335 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type conditionalTransport struct {
+	etag map[string]string
+	body map[string]string
+}
+
+func (ct *conditionalTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res := new(http.Response)
+
+	res.Request = req
+	res.Header = http.Header{}
+
+	key := req.URL.Path
+
+	etag, found := ct.etag[key]
+	if !found {
+		res.StatusCode = http.StatusNotFound
+		res.Body = io.NopCloser(strings.NewReader(""))
+
+		return res, nil
+	}
+
+	res.Header.Set(hdrETag, etag)
+
+	if req.Header.Get(hdrIfNoneMatch) == etag {
+		res.StatusCode = http.StatusNotModified
+		res.Body = io.NopCloser(strings.NewReader(""))
+
+		return res, nil
+	}
+
+	res.StatusCode = http.StatusOK
+	res.Body = io.NopCloser(strings.NewReader(ct.body[key]))
+
+	return res, nil
+}
+
+func TestTripperware_refresh(t *testing.T) {
+	t.Parallel()
+
+	transport := &conditionalTransport{
+		etag: map[string]string{"/a.js": `"a1"`, "/b.js": `"b1"`, "/c.js": `"c1"`},
+		body: map[string]string{"/a.js": "a1", "/b.js": "b1", "/c.js": "c1"},
+	}
+
+	recorder := newTripperware(modeRecord, transport, logrus.StandardLogger())
+
+	for _, path := range []string{"/a.js", "/b.js", "/c.js"} {
+		req := new(http.Request)
+		req.URL, _ = url.Parse("https://example.com" + path + "?_k6=1")
+
+		res, err := recorder.RoundTrip(req)
+
+		assert.NoError(t, err)
+		assert.NoError(t, res.Body.Close())
+	}
+
+	rep, _ := recorder.history.get(&url.URL{Scheme: "https", Host: "example.com", Path: "/a.js", RawQuery: "_k6=1"})
+
+	assert.Equal(t, `"a1"`, rep.header.Get(hdrETag))
+
+	transport.etag["/b.js"] = `"b2"`
+	transport.body["/b.js"] = "b2"
+	transport.etag["/c.js"] = `"c2"`
+	transport.body["/c.js"] = "c2"
+
+	tw := newTripperware(modeRefresh, transport, logrus.StandardLogger()) // nolint:varnamelen
+
+	tw.history = recorder.history
+
+	req := new(http.Request)
+	req.URL, _ = url.Parse("https://example.com/a.js?_k6=1")
+
+	res, err := tw.RoundTrip(req)
+
+	assert.NoError(t, err)
+
+	body, err := io.ReadAll(res.Body)
+
+	assert.NoError(t, err)
+	assert.NoError(t, res.Body.Close())
+	assert.Equal(t, "a1", string(body))
+	assert.Zero(t, tw.recorded.Load())
+
+	req = new(http.Request)
+	req.URL, _ = url.Parse("https://example.com/b.js?_k6=1")
+
+	res, err = tw.RoundTrip(req)
+
+	assert.NoError(t, err)
+
+	body, err = io.ReadAll(res.Body)
+
+	assert.NoError(t, err)
+	assert.NoError(t, res.Body.Close())
+	assert.Equal(t, "b2", string(body))
+	assert.Equal(t, int64(1), tw.recorded.Load())
+
+	tw.refresh(context.Background())
+
+	assert.Equal(t, int64(2), tw.recorded.Load())
+
+	rep, _ = tw.history.get(&url.URL{Scheme: "https", Host: "example.com", Path: "/c.js", RawQuery: "_k6=1"})
+
+	assert.Equal(t, "c2", string(rep.body))
+	assert.Equal(t, `"c2"`, rep.header.Get(hdrETag))
+
+	delete(transport.etag, "/a.js")
+
+	tw = newTripperware(modeRefresh, transport, logrus.StandardLogger())
+	tw.history = recorder.history
+
+	tw.refresh(context.Background())
+
+	assert.Zero(t, tw.recorded.Load())
+
+	rep, _ = tw.history.get(&url.URL{Scheme: "https", Host: "example.com", Path: "/a.js", RawQuery: "_k6=1"})
+
+	assert.Equal(t, "a1", string(rep.body))
+}
+
+func TestTripperware_revalidate_failed(t *testing.T) {
+	t.Parallel()
+
+	transport := &failingTransport{
+		errors:   map[string]bool{"down.example.com": true},
+		statuses: map[string]int{"gone.example.com": http.StatusNotFound, "broken.example.com": http.StatusBadGateway},
+		hosts:    nil,
+	}
+
+	for _, host := range []string{"down.example.com", "gone.example.com", "broken.example.com"} {
+		tw := newTripperware(modeRefresh, transport, logrus.StandardLogger()) // nolint:varnamelen
+
+		loc := &url.URL{Scheme: "https", Host: host, Path: "/index.js", RawQuery: "_k6=1"}
+
+		tw.history.put(loc, &reply{header: http.Header{hdrContentType: {"text/javascript"}}, body: []byte("cached")})
+
+		req := new(http.Request)
+		req.URL = loc
+
+		res, err := tw.RoundTrip(req)
+
+		assert.NoError(t, err, host)
+
+		body, err := io.ReadAll(res.Body)
+
+		assert.NoError(t, err)
+		assert.NoError(t, res.Body.Close())
+		assert.Equal(t, http.StatusOK, res.StatusCode, host)
+		assert.Equal(t, "cached", string(body), host)
+		assert.Zero(t, tw.recorded.Load(), host)
+	}
+}
+
+func TestTripperware_revalidate_noStore(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, time.May, 1, 12, 0, 0, 0, time.UTC)
+	transport := &countingTransport{header: http.Header{hdrCacheControl: {"no-store"}}}
+
+	tw := newTripperware(modeTTL, transport, logrus.StandardLogger()) // nolint:varnamelen
+
+	tw.clock = func() time.Time { return now }
+
+	loc, _ := url.Parse("https://example.com/index.js?_k6=1")
+
+	stale := now.Add(-time.Hour).Format(http.TimeFormat)
+
+	tw.history.put(loc, &reply{header: http.Header{hdrDate: {stale}, hdrCacheControl: {"max-age=60"}}, body: []byte("OLD")})
+
+	for range []int{1, 2} {
+		req := new(http.Request)
+		req.URL = loc
+
+		res, err := tw.RoundTrip(req)
+
+		assert.NoError(t, err)
+
+		body, err := io.ReadAll(res.Body)
+
+		assert.NoError(t, err)
+		assert.NoError(t, res.Body.Close())
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "Hello World!", string(body))
+	}
+
+	_, found := tw.history.get(loc)
+
+	assert.False(t, found)
+	assert.Equal(t, 2, transport.requests)
+	assert.NotZero(t, tw.recorded.Load())
+
+	// not storable media type
+	transport.header = http.Header{hdrContentType: {"application/octet-stream"}}
+
+	tw = newTripperware(modeRefresh, transport, logrus.StandardLogger())
+
+	tw.history.put(loc, &reply{header: http.Header{}, body: []byte("OLD")})
+
+	req := new(http.Request)
+	req.URL = loc
+
+	res, err := tw.RoundTrip(req)
+
+	assert.NoError(t, err)
+
+	body, err := io.ReadAll(res.Body)
+
+	assert.NoError(t, err)
+	assert.NoError(t, res.Body.Close())
+	assert.Equal(t, "Hello World!", string(body))
+}
+
+type countingTransport struct {
+	header   http.Header
+	requests int
+}
+
+func (ct *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ct.requests++
+
+	res := new(http.Response)
+
+	res.Request = req
+	res.Header = cloneHeader(ct.header)
+	res.StatusCode = http.StatusOK
+	res.Body = io.NopCloser(strings.NewReader("Hello World!"))
+
+	return res, nil
+}
+
+func TestTripperware_RoundTrip_ttl(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, time.May, 1, 12, 0, 0, 0, time.UTC)
+	transport := &countingTransport{header: http.Header{hdrCacheControl: {"max-age=60"}}}
+
+	tw := newTripperware(modeTTL, transport, logrus.StandardLogger()) // nolint:varnamelen
+
+	tw.clock = func() time.Time { return now }
+
+	get := func() {
+		req := new(http.Request)
+		req.URL, _ = url.Parse("https://example.com/index.js?_k6=1")
+
+		res, err := tw.RoundTrip(req)
+
+		assert.NoError(t, err)
+
+		body, err := io.ReadAll(res.Body)
+
+		assert.NoError(t, err)
+		assert.NoError(t, res.Body.Close())
+		assert.Equal(t, "Hello World!", string(body))
+	}
+
+	get()
+
+	assert.Equal(t, 1, transport.requests)
+
+	rep, _ := tw.history.get(&url.URL{Scheme: "https", Host: "example.com", Path: "/index.js", RawQuery: "_k6=1"})
+
+	assert.Equal(t, now.Format(http.TimeFormat), rep.header.Get(hdrDate))
+	assert.Equal(t, "max-age=60", rep.header.Get(hdrCacheControl))
+
+	now = now.Add(30 * time.Second)
+
+	get()
+
+	assert.Equal(t, 1, transport.requests)
+
+	now = now.Add(time.Minute)
+
+	get()
+
+	assert.Equal(t, 2, transport.requests)
+
+	rep, _ = tw.history.get(&url.URL{Scheme: "https", Host: "example.com", Path: "/index.js", RawQuery: "_k6=1"})
+
+	assert.Equal(t, now.Format(http.TimeFormat), rep.header.Get(hdrDate))
+
+	transport.header.Set(hdrCacheControl, "no-store")
+
+	tw = newTripperware(modeTTL, transport, logrus.StandardLogger())
+
+	get()
+
+	assert.Empty(t, tw.history.store)
+}
+
+func TestTripperware_revalidate_ttl(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, time.May, 1, 12, 0, 0, 0, time.UTC)
+	transport := &conditionalTransport{
+		etag: map[string]string{"/a.js": `"a1"`},
+		body: map[string]string{"/a.js": "a1"},
+	}
+
+	tw := newTripperware(modeTTL, transport, logrus.StandardLogger()) // nolint:varnamelen
+
+	tw.clock = func() time.Time { return now }
+
+	loc, _ := url.Parse("https://example.com/a.js?_k6=1")
+
+	tw.history.put(loc, &reply{header: http.Header{hdrETag: {`"a1"`}}, body: []byte("a1")})
+
+	req := new(http.Request)
+	req.URL = loc
+
+	res, err := tw.RoundTrip(req)
+
+	assert.NoError(t, err)
+	assert.NoError(t, res.Body.Close())
+	assert.Equal(t, int64(1), tw.recorded.Load())
+
+	rep, _ := tw.history.get(loc)
+
+	assert.Equal(t, now.Format(http.TimeFormat), rep.header.Get(hdrDate))
+	assert.Equal(t, "a1", string(rep.body))
+}
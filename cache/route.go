@@ -0,0 +1,179 @@
+// SPDX-FileCopyrightText: 2023 Iván Szkiba
+//
+// SPDX-License-Identifier: MIT
+
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// routing decides where modules are fetched from. Entries are always stored under
+// the original URL, so the cache does not change when the routes change.
+type routing struct {
+	// rewrites replace the matching URL prefix before fetching, the first matching rule wins.
+	rewrites []*route
+	// mirrors lists the alternatives tried in order when fetching from the matching URL prefix fails.
+	mirrors []*route
+}
+
+type route struct {
+	from string
+	to   []string
+}
+
+func newRoute(from string, to ...string) (*route, error) {
+	for _, prefix := range append([]string{from}, to...) {
+		loc, err := url.Parse(prefix)
+		if err != nil || !loc.IsAbs() || len(loc.Host) == 0 {
+			return nil, fmt.Errorf("%w: %q", errInvalidRoute, prefix)
+		}
+	}
+
+	if len(to) == 0 {
+		return nil, fmt.Errorf("%w: missing target of %q", errInvalidRoute, from)
+	}
+
+	return &route{from: from, to: to}, nil
+}
+
+func matchRoute(routes []*route, str string) (*route, bool) {
+	for _, r := range routes {
+		if strings.HasPrefix(str, r.from) {
+			return r, true
+		}
+	}
+
+	return nil, false
+}
+
+// candidates returns the URLs to fetch the module at loc from, in order.
+func (r *routing) candidates(loc *url.URL) ([]*url.URL, error) {
+	str := loc.String()
+
+	if r == nil {
+		return []*url.URL{loc}, nil
+	}
+
+	primary := str
+
+	if rewrite, found := matchRoute(r.rewrites, str); found {
+		primary = rewrite.to[0] + strings.TrimPrefix(str, rewrite.from)
+	}
+
+	targets := []string{primary}
+
+	if mirror, found := matchRoute(r.mirrors, str); found {
+		for _, prefix := range mirror.to {
+			targets = append(targets, prefix+strings.TrimPrefix(str, mirror.from))
+		}
+	}
+
+	locs := make([]*url.URL, 0, len(targets))
+
+	for _, target := range targets {
+		candidate, err := url.Parse(target)
+		if err != nil {
+			return nil, err
+		}
+
+		locs = append(locs, candidate)
+	}
+
+	return locs, nil
+}
+
+// origin maps target, a URL under the routed candidate URL of the module at loc, back under
+// the prefix of loc. It reports false if target is not under the routed prefix.
+func (r *routing) origin(loc, candidate, target *url.URL) (*url.URL, bool) {
+	if r == nil {
+		return nil, false
+	}
+
+	str := loc.String()
+
+	for _, routes := range [][]*route{r.rewrites, r.mirrors} {
+		rule, found := matchRoute(routes, str)
+		if !found {
+			continue
+		}
+
+		suffix := strings.TrimPrefix(str, rule.from)
+
+		for _, prefix := range rule.to {
+			if candidate.String() != prefix+suffix || !strings.HasPrefix(target.String(), prefix) {
+				continue
+			}
+
+			mapped, err := url.Parse(rule.from + strings.TrimPrefix(target.String(), prefix))
+			if err != nil {
+				return nil, false
+			}
+
+			return mapped, true
+		}
+	}
+
+	return nil, false
+}
+
+// fetch sends the request to the routed URL, falling back to the mirrors if it fails.
+// The response refers to the original request, redirects into the routed prefix are mapped back
+// under the original one, so the routed hosts never get into the cache.
+func (tw *tripperware) fetch(req *http.Request) (*http.Response, error) {
+	locs, err := tw.routes.candidates(req.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	var res *http.Response
+
+	var used *url.URL
+
+	for idx, loc := range locs {
+		used = loc
+
+		if idx != 0 {
+			tw.logger.WithField("url", req.URL.String()).WithField("mirror", loc.String()).Warn("fetching module from mirror")
+		}
+
+		routed := req
+
+		if loc.String() != req.URL.String() {
+			routed = req.Clone(req.Context())
+			routed.URL = loc
+			routed.Host = ""
+		}
+
+		res, err = tw.transport.RoundTrip(routed)
+		if err == nil && res.StatusCode < http.StatusInternalServerError {
+			break
+		}
+
+		if err == nil && idx != len(locs)-1 {
+			res.Body.Close() //nolint:errcheck,gosec
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if used.String() != req.URL.String() && isRedirect(res.StatusCode, res.Header) {
+		if target, err := used.Parse(res.Header.Get(hdrLocation)); err == nil {
+			if mapped, found := tw.routes.origin(req.URL, used, target); found {
+				res.Header.Set(hdrLocation, mapped.String())
+			}
+		}
+	}
+
+	res.Request = req
+
+	return res, nil
+}
+
+var errInvalidRoute = errors.New("invalid rewrite or mirror rule")
@@ -0,0 +1,211 @@
+package cache
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRouting(t *testing.T) *routing {
+	t.Helper()
+
+	rewrite, err := newRoute("https://jslib.k6.io/", "https://mirror.example.com/jslib/")
+
+	assert.NoError(t, err)
+
+	mirror, err := newRoute("https://jslib.k6.io/", "https://backup.example.com/jslib/", "https://other.example.com/")
+
+	assert.NoError(t, err)
+
+	return &routing{rewrites: []*route{rewrite}, mirrors: []*route{mirror}}
+}
+
+func TestNewRoute(t *testing.T) {
+	t.Parallel()
+
+	_, err := newRoute("https://jslib.k6.io/")
+
+	assert.ErrorIs(t, err, errInvalidRoute)
+
+	_, err = newRoute("jslib.k6.io", "https://mirror.example.com/")
+
+	assert.ErrorIs(t, err, errInvalidRoute)
+
+	_, err = newRoute("https://jslib.k6.io/", "")
+
+	assert.ErrorIs(t, err, errInvalidRoute)
+}
+
+func TestRouting_candidates(t *testing.T) {
+	t.Parallel()
+
+	routes := newTestRouting(t)
+
+	locate := func(r *routing, str string) []string {
+		loc, _ := url.Parse(str)
+
+		locs, err := r.candidates(loc)
+
+		assert.NoError(t, err)
+
+		all := []string{}
+
+		for _, candidate := range locs {
+			all = append(all, candidate.String())
+		}
+
+		return all
+	}
+
+	assert.Equal(t, []string{
+		"https://mirror.example.com/jslib/k6-utils/1.4.0/index.js?_k6=1",
+		"https://backup.example.com/jslib/k6-utils/1.4.0/index.js?_k6=1",
+		"https://other.example.com/k6-utils/1.4.0/index.js?_k6=1",
+	}, locate(routes, "https://jslib.k6.io/k6-utils/1.4.0/index.js?_k6=1"))
+
+	assert.Equal(t, []string{"https://example.com/a.js"}, locate(routes, "https://example.com/a.js"))
+	assert.Equal(t, []string{"https://jslib.k6.io/a.js"}, locate(nil, "https://jslib.k6.io/a.js"))
+}
+
+// failingTransport fails the requests to the listed hosts.
+type failingTransport struct {
+	errors   map[string]bool
+	statuses map[string]int
+	hosts    []string
+}
+
+func (ft *failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ft.hosts = append(ft.hosts, req.URL.Host)
+
+	if ft.errors[req.URL.Host] {
+		return nil, errors.New("connection refused") //nolint:goerr113
+	}
+
+	res := &http.Response{StatusCode: http.StatusOK, Request: req, Header: http.Header{}} //nolint:exhaustruct
+
+	if status, found := ft.statuses[req.URL.Host]; found {
+		res.StatusCode = status
+	}
+
+	res.Body = io.NopCloser(strings.NewReader(req.URL.String()))
+
+	return res, nil
+}
+
+func TestTripperware_fetch(t *testing.T) {
+	t.Parallel()
+
+	transport := &failingTransport{
+		errors:   map[string]bool{"mirror.example.com": true},
+		statuses: map[string]int{"backup.example.com": http.StatusBadGateway},
+		hosts:    nil,
+	}
+
+	tw := newTripperware(modeRecord, transport, logrus.StandardLogger())
+
+	tw.routes = newTestRouting(t)
+
+	req := new(http.Request)
+	req.URL, _ = url.Parse("https://jslib.k6.io/k6-utils/1.4.0/index.js?_k6=1")
+
+	res, err := tw.RoundTrip(req)
+
+	assert.NoError(t, err)
+	assert.NoError(t, res.Body.Close())
+	assert.Same(t, req, res.Request)
+	assert.Equal(t, []string{"mirror.example.com", "backup.example.com", "other.example.com"}, transport.hosts)
+
+	rep, found := tw.history.get(req.URL)
+
+	assert.True(t, found)
+	assert.Equal(t, "https://other.example.com/k6-utils/1.4.0/index.js?_k6=1", string(rep.body))
+
+	transport.errors["other.example.com"] = true
+
+	req = new(http.Request)
+	req.URL, _ = url.Parse("https://jslib.k6.io/missing.js?_k6=1")
+
+	_, err = tw.RoundTrip(req) //nolint:bodyclose
+
+	assert.Error(t, err)
+
+	delete(transport.errors, "mirror.example.com")
+
+	transport.statuses["mirror.example.com"] = http.StatusNotFound
+	transport.hosts = nil
+
+	res, err = tw.RoundTrip(req)
+
+	assert.NoError(t, err)
+	assert.NoError(t, res.Body.Close())
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	assert.Equal(t, []string{"mirror.example.com"}, transport.hosts)
+}
+
+// mirrorTransport serves the jslib mirror only, /jslib/lodash is redirected to /jslib/lodash@4/lodash.js.
+type mirrorTransport struct {
+	urls []string
+}
+
+func (mt *mirrorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	mt.urls = append(mt.urls, req.URL.String())
+
+	res := &http.Response{StatusCode: http.StatusOK, Request: req, Header: http.Header{}} //nolint:exhaustruct
+
+	switch {
+	case req.URL.Host != "mirror.example.com":
+		return nil, errors.New("connection refused") //nolint:goerr113
+	case req.URL.Path == "/jslib/lodash":
+		res.StatusCode = http.StatusFound
+		res.Header.Set(hdrLocation, "/jslib/lodash@4/lodash.js")
+	default:
+		res.Header.Set(hdrContentType, "application/javascript")
+	}
+
+	res.Body = io.NopCloser(strings.NewReader("// " + req.URL.Path))
+
+	return res, nil
+}
+
+func TestTripperware_fetchRedirect(t *testing.T) {
+	t.Parallel()
+
+	transport := new(mirrorTransport)
+
+	tw := newTripperware(modeRecord, transport, logrus.StandardLogger())
+
+	tw.routes = newTestRouting(t)
+
+	client := &http.Client{Transport: tw} //nolint:exhaustruct
+
+	res, err := client.Get("https://jslib.k6.io/lodash?_k6=1") //nolint:noctx
+
+	assert.NoError(t, err)
+
+	body, err := io.ReadAll(res.Body)
+
+	assert.NoError(t, err)
+	assert.NoError(t, res.Body.Close())
+	assert.Equal(t, "// /jslib/lodash@4/lodash.js", string(body))
+	assert.Equal(t, []string{
+		"https://mirror.example.com/jslib/lodash?_k6=1",
+		"https://mirror.example.com/jslib/lodash@4/lodash.js",
+	}, transport.urls)
+
+	assert.Equal(t, []string{
+		"",
+		"https://jslib.k6.io/lodash?_k6=1",
+		"https://jslib.k6.io/lodash@4/lodash.js?_k6=1",
+	}, tw.history.keys())
+
+	rep, found := tw.history.lookup("https://jslib.k6.io/lodash?_k6=1")
+
+	assert.True(t, found)
+	assert.Equal(t, "https://jslib.k6.io/lodash@4/lodash.js", rep.header.Get(hdrLocation))
+}
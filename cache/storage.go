@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: 2023 Iván Szkiba
+//
+// SPDX-License-Identifier: MIT
+
+package cache
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// storage persists the history. Errors of missing storage wrap os.ErrNotExist.
+type storage interface {
+	// load adds the stored entries to the history.
+	load(h *history) error
+	// save replaces the stored entries with the entries of the history.
+	save(h *history) error
+	// stat returns the file info used to detect changes made by other processes.
+	stat() (os.FileInfo, error)
+}
+
+// newStorage returns the storage backend for filename. Names ending with a path separator
+// and existing directories denote the directory tree backend, .tar, .tar.gz (.tgz) and .zip
+// extensions denote archives, others the email format file, compressed if the extension
+// is .gz or .zst (for example vendor.eml.gz).
+func newStorage(filename string) storage {
+	if strings.HasSuffix(filename, "/") || strings.HasSuffix(filename, string(os.PathSeparator)) {
+		return &dirStorage{dirname: filename}
+	}
+
+	if info, err := os.Stat(filename); err == nil && info.IsDir() {
+		return &dirStorage{dirname: filename}
+	}
+
+	if format, found := archiveFormatOf(filename); found {
+		return &archiveStorage{filename: filename, format: format}
+	}
+
+	return &fileStorage{filename: filename, compression: compressionOf(filename)}
+}
+
+// fileStorage stores the history in a single, optionally compressed email format file.
+type fileStorage struct {
+	filename    string
+	compression compression
+}
+
+// load indexes uncompressed files, so the bodies are read on demand (see history.index).
+// Compressed files are read into memory.
+func (s *fileStorage) load(h *history) error {
+	file, err := os.Open(s.filename)
+	if err != nil {
+		return err
+	}
+
+	if s.compression == compressNone {
+		if err := h.index(file, file); err != nil {
+			file.Close() //nolint:errcheck,gosec
+
+			return fmt.Errorf("%s: %w", s.filename, err)
+		}
+
+		return nil
+	}
+
+	defer file.Close() //nolint:errcheck
+
+	reader, err := s.compression.decompressor(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.filename, err)
+	}
+
+	defer reader.Close() //nolint:errcheck
+
+	return h.unmarshal(reader)
+}
+
+func (s *fileStorage) save(h *history) error {
+	return writeFile(s.filename, func(writer io.Writer) error {
+		out, err := s.compression.compressor(writer)
+		if err != nil {
+			return err
+		}
+
+		if err := h.marshal(out); err != nil {
+			out.Close() //nolint:errcheck,gosec
+
+			return err
+		}
+
+		return out.Close()
+	})
+}
+
+func (s *fileStorage) stat() (os.FileInfo, error) {
+	return os.Stat(s.filename)
+}
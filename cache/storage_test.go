@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewStorage(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	assert.IsType(t, &fileStorage{}, newStorage(filepath.Join(dir, "vendor.eml")))
+	assert.IsType(t, &dirStorage{}, newStorage(filepath.Join(dir, "vendor")+string(os.PathSeparator)))
+	assert.IsType(t, &dirStorage{}, newStorage("vendor/"))
+	assert.IsType(t, &dirStorage{}, newStorage(dir))
+}
+
+func TestFileStorage(t *testing.T) {
+	t.Parallel()
+
+	store := newStorage(filepath.Join(t.TempDir(), "vendor.eml"))
+
+	_, err := store.stat()
+
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.ErrorIs(t, store.load(new(history)), os.ErrNotExist)
+
+	from := newTestHistory(t)
+
+	assert.NoError(t, store.save(from))
+
+	_, err = store.stat()
+
+	assert.NoError(t, err)
+
+	to := new(history)
+
+	assert.NoError(t, store.load(to))
+	assert.NotNil(t, to.lazy)
+	assert.NoError(t, to.materialize())
+	assert.Nil(t, to.lazy)
+	assert.Equal(t, from.store, to.store)
+}
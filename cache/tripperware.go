@@ -6,106 +6,344 @@ package cache
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"mime"
 	"net/http"
 	"os"
-	"strings"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
 type tripperware struct {
+	mode      mode
 	transport http.RoundTripper
+	policy    *policy
+	routes    *routing
 	history   *history
 	logger    logrus.FieldLogger
+	filename  string
+	recorded  atomic.Int64
+	// snapshot is the state of the cache file at startup, nil if it was missing.
+	snapshot os.FileInfo
+	// revalidated holds the keys revalidated in refresh mode.
+	revalidated sync.Map
+	// pinned holds the integrity metadata of the redirect targets of imports having one,
+	// keyed by canonical URL, as the HTTP client drops the fragment when following a redirect.
+	pinned sync.Map
+	clock  func() time.Time
 }
 
-func newTripperware(transport http.RoundTripper, logger logrus.FieldLogger) *tripperware {
-	return &tripperware{transport: transport, history: new(history), logger: logger}
+func newTripperware(mod mode, transport http.RoundTripper, logger logrus.FieldLogger) *tripperware {
+	return &tripperware{
+		mode:      mod,
+		transport: transport,
+		policy:    defaultPolicy(),
+		history:   new(history),
+		logger:    logger,
+		clock:     time.Now,
+	}
 }
 
 func (tw *tripperware) shouldStore(res *http.Response) bool {
-	if res.StatusCode != http.StatusOK {
+	if res.StatusCode != http.StatusOK && !isRedirect(res.StatusCode, res.Header) {
 		return false
 	}
 
+	if tw.mode == modeTTL && noStore(res.Header) {
+		return false
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return true
+	}
+
+	if isLoaderMetadata(res) {
+		return true
+	}
+
 	mediatype, _, err := mime.ParseMediaType(res.Header.Get(hdrContentType))
 	if err != nil {
 		return true
 	}
 
-	return strings.HasPrefix(mediatype, "text") || strings.Contains(mediatype, "javascript")
+	return tw.policy.storable(mediatype)
 }
 
+// RoundTrip verifies the body of successful responses against the subresource integrity metadata
+// in the URL fragment (if any), for example https://example.com/index.js#sha384-...
+// Redirects pass the metadata on to their target.
 func (tw *tripperware) RoundTrip(req *http.Request) (*http.Response, error) {
+	metadata := req.URL.Fragment
+	if !isIntegrity(metadata) {
+		pinned, found := tw.pinned.LoadAndDelete(canonicalKey(req.URL))
+		if !found {
+			return tw.roundTrip(req, "")
+		}
+
+		metadata, _ = pinned.(string)
+	}
+
+	stripped := req.Clone(req.Context())
+
+	stripped.URL.Fragment = ""
+	stripped.URL.RawFragment = ""
+
+	return tw.roundTrip(stripped, metadata)
+}
+
+func (tw *tripperware) roundTrip(req *http.Request, metadata string) (*http.Response, error) {
+	if tw.mode == modePassthrough {
+		return tw.fetchVerified(req, metadata)
+	}
+
+	if !tw.policy.cacheable(req.URL) {
+		if !tw.mode.fetches() {
+			return nil, fmt.Errorf("%w: %s bypasses the cache (%s mode)", errCacheMiss, req.URL, tw.mode)
+		}
+
+		return tw.fetchVerified(req, metadata)
+	}
+
 	log := tw.logger.WithField("url", req.URL.String())
 
-	if rep, ok := tw.history.get(req.URL); ok {
-		log.Debug("cache hit")
+	rep, cached, err := tw.find(req.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	if tw.mode.revalidates() {
+		if cached {
+			if tw.mode == modeTTL && fresh(rep.header, tw.clock()) {
+				log.Debug("cache hit")
+
+				return tw.verified(req, reply2response(req, rep), metadata)
+			}
+
+			log.Debug("cache revalidate")
 
-		return reply2response(req, rep), nil
+			return tw.revalidate(req, rep, metadata)
+		}
 	}
 
-	log.Debug("cache miss")
+	if tw.mode.lookups() {
+		if cached {
+			log.Debug("cache hit")
+
+			return tw.verified(req, reply2response(req, rep), metadata)
+		}
+
+		log.Debug("cache miss")
+	}
+
+	if !tw.mode.fetches() {
+		return nil, fmt.Errorf("%w: %s not found in %s (%s mode)", errCacheMiss, req.URL, tw.filename, tw.mode)
+	}
 
 	req.Header.Del(hdrAcceptEncoding) // avoid compressed response
 
-	res, err := tw.transport.RoundTrip(req)
-	if err != nil || !tw.shouldStore(res) {
+	res, err := tw.fetchVerified(req, metadata)
+	if err != nil || !tw.mode.records() || !tw.shouldStore(res) {
 		return res, err
 	}
 
-	rep, err := response2reply(res)
+	return tw.record(req, res)
+}
+
+// fetchVerified is fetch followed by verified.
+func (tw *tripperware) fetchVerified(req *http.Request, metadata string) (*http.Response, error) {
+	res, err := tw.fetch(req)
 	if err != nil {
 		return nil, err
 	}
 
+	return tw.verified(req, res, metadata)
+}
+
+// verified returns the response if its body matches the integrity metadata (if any),
+// so a module failing the verification never gets recorded. The metadata of a redirect
+// is pinned to its target, other unsuccessful responses are not verified.
+func (tw *tripperware) verified(req *http.Request, res *http.Response, metadata string) (*http.Response, error) {
+	if len(metadata) == 0 {
+		return res, nil
+	}
+
+	if isRedirect(res.StatusCode, res.Header) {
+		target, err := req.URL.Parse(res.Header.Get(hdrLocation))
+		if err != nil {
+			res.Body.Close() //nolint:errcheck,gosec
+
+			return nil, err
+		}
+
+		tw.pinned.Store(canonicalKey(target), metadata)
+
+		return res, nil
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return res, nil
+	}
+
+	body, err := duplicateBody(res)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifyIntegrity(metadata, body); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, req.URL)
+	}
+
+	return res, nil
+}
+
+// record stores the response into the history.
+func (tw *tripperware) record(req *http.Request, res *http.Response) (*http.Response, error) {
+	rep, err := response2reply(res, tw.policy)
+	if err != nil {
+		return nil, err
+	}
+
+	if tw.mode == modeTTL {
+		tw.stamp(rep.header, res.Header)
+	}
+
 	loc := *req.URL
 
 	addK6QueryParam(&loc)
 
 	tw.history.put(&loc, rep)
+	tw.recorded.Add(1)
 
 	return res, nil
 }
 
+// stamp sets the fetch time and copies the caching headers of a response into the header of an entry.
+func (tw *tripperware) stamp(header http.Header, from http.Header) {
+	header.Set(hdrDate, tw.clock().UTC().Format(http.TimeFormat))
+
+	for _, key := range []string{hdrCacheControl, hdrExpires} {
+		if values := from.Values(key); len(values) != 0 {
+			header[key] = append([]string(nil), values...)
+		}
+	}
+}
+
 func (tw *tripperware) save(filename string) error {
-	tw.logger.WithField("size", len(tw.history.store)).Debug("history summary")
+	tw.logger.WithField("size", len(tw.history.keys())).Debug("history summary")
 
-	file, err := os.Create(filename)
+	if len(filename) == 0 {
+		return errMissingFilename
+	}
+
+	unlock, err := lockFile(filepath.Clean(filename))
 	if err != nil {
 		return err
 	}
 
-	if err := tw.history.marshal(file); err != nil {
+	defer unlock() //nolint:errcheck
+
+	store := newStorage(filename)
+
+	if err := tw.merge(store); err != nil {
 		return err
 	}
 
-	return file.Close()
+	return store.save(tw.history)
 }
 
-func response2reply(resp *http.Response) (*reply, error) {
+// merge unions the in-memory history with the stored one when it has been changed
+// by another process since startup. In-memory entries take precedence.
+func (tw *tripperware) merge(store storage) error {
+	info, err := store.stat()
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	if info.Size() == 0 {
+		return nil
+	}
+
+	if tw.snapshot != nil && os.SameFile(info, tw.snapshot) &&
+		info.ModTime().Equal(tw.snapshot.ModTime()) && info.Size() == tw.snapshot.Size() {
+		return nil
+	}
+
+	tw.logger.Debug("cache file changed since startup, merging")
+
+	disk := new(history)
+
+	if err := store.load(disk); err != nil {
+		return err
+	}
+
+	if err := disk.materialize(); err != nil {
+		return err
+	}
+
+	tw.history.merge(disk)
+
+	return nil
+}
+
+func response2reply(resp *http.Response, pol *policy) (*reply, error) {
 	body, err := duplicateBody(resp)
 	if err != nil {
 		return nil, err
 	}
 
-	return &reply{header: filterHeader(resp.Header), body: body}, nil
+	header := filterHeader(resp.Header, pol)
+
+	header.Del(hdrStatus)
+
+	rep := &reply{header: header, body: body}
+
+	if resp.StatusCode != http.StatusOK && isRedirect(resp.StatusCode, resp.Header) {
+		rep.status = resp.StatusCode
+		header.Set(hdrLocation, resp.Header.Get(hdrLocation))
+	}
+
+	return rep, nil
 }
 
+// reply2response returns the response of a cached entry. The body related headers
+// are restored even if the policy did not persist them.
 func reply2response(req *http.Request, rep *reply) *http.Response {
+	header := cloneHeader(rep.header)
+
+	header.Del(hdrStatus)
+	header.Set(hdrContentLength, strconv.Itoa(len(rep.body)))
+
+	if len(header.Get(hdrContentType)) == 0 {
+		header.Set(hdrContentType, http.DetectContentType(rep.body))
+	}
+
 	return &http.Response{ // nolint:exhaustruct
-		Status:        http.StatusText(http.StatusOK),
-		StatusCode:    http.StatusOK,
+		Status:        http.StatusText(rep.code()),
+		StatusCode:    rep.code(),
 		Proto:         "HTTP/1.1",
 		ProtoMajor:    1,
 		ProtoMinor:    1,
 		Body:          io.NopCloser(bytes.NewBuffer(rep.body)),
 		ContentLength: int64(len(rep.body)),
 		Request:       req,
-		Header:        cloneHeader(rep.header),
+		Header:        header,
 	}
 }
 
 const hdrAcceptEncoding = "Accept-Encoding"
+
+var (
+	errCacheMiss       = errors.New("cache miss")
+	errMissingFilename = errors.New("missing cache file name")
+)
@@ -2,6 +2,7 @@ package cache
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"net/http"
 	"net/url"
@@ -25,7 +26,7 @@ func Test_response2reply(t *testing.T) {
 		Header:        hdr,
 	}
 
-	rep, err := response2reply(from)
+	rep, err := response2reply(from, defaultPolicy())
 
 	assert.NoError(t, err)
 	assert.NotNil(t, rep)
@@ -47,7 +48,7 @@ func Test_response2reply(t *testing.T) {
 
 	from.Body = file
 
-	_, err = response2reply(from)
+	_, err = response2reply(from, defaultPolicy())
 
 	assert.Error(t, err)
 }
@@ -68,7 +69,9 @@ func Test_reply2respose(t *testing.T) {
 	assert.Equal(t, "HTTP/1.1", res.Proto)
 	assert.Equal(t, 1, res.ProtoMajor)
 	assert.Equal(t, 1, res.ProtoMinor)
-	assert.Equal(t, from.header, res.Header)
+	assert.Equal(t, "Bar", res.Header.Get("Foo"))
+	assert.Equal(t, "12", res.Header.Get(hdrContentLength))
+	assert.Equal(t, "text/plain; charset=utf-8", res.Header.Get(hdrContentType))
 	assert.Equal(t, int64(len(from.body)), res.ContentLength)
 
 	body, err := io.ReadAll(res.Body)
@@ -126,7 +129,7 @@ func TestTripperware_save(t *testing.T) {
 func TestTripperware_shouldStore(t *testing.T) {
 	t.Parallel()
 
-	tw := new(tripperware) // nolint:varnamelen
+	tw := newTripperware(modeRecord, nil, logrus.StandardLogger()) // nolint:varnamelen
 	res := new(http.Response)
 
 	res.StatusCode = http.StatusBadRequest
@@ -158,6 +161,19 @@ func TestTripperware_shouldStore(t *testing.T) {
 	res.Header.Set("Content-Type", "text/plain")
 
 	assert.True(t, tw.shouldStore(res))
+
+	res.Header.Set("Content-Type", "application/wasm")
+
+	assert.True(t, tw.shouldStore(res))
+
+	res.StatusCode = http.StatusMovedPermanently
+
+	assert.False(t, tw.shouldStore(res))
+
+	res.Header.Set("Location", "https://example.com/")
+	res.Header.Set("Content-Type", "application/octet-stream")
+
+	assert.True(t, tw.shouldStore(res))
 }
 
 func TestTripperware_RoundTrip_miss(t *testing.T) {
@@ -165,7 +181,7 @@ func TestTripperware_RoundTrip_miss(t *testing.T) {
 
 	transport := newTransport(t)
 
-	tw := newTripperware(transport, logrus.StandardLogger()) // nolint:varnamelen
+	tw := newTripperware(modeRecord, transport, logrus.StandardLogger()) // nolint:varnamelen
 
 	assert.NotNil(t, tw.history)
 
@@ -188,7 +204,7 @@ func TestTripperware_RoundTrip_hit(t *testing.T) {
 
 	transport := newTransport(t)
 
-	tw := newTripperware(transport, logrus.StandardLogger()) // nolint:varnamelen
+	tw := newTripperware(modeRecord, transport, logrus.StandardLogger()) // nolint:varnamelen
 
 	assert.NotNil(t, tw.history)
 
@@ -233,3 +249,214 @@ func newTransport(t *testing.T) *testTransport {
 
 	return tt
 }
+
+func TestTripperware_RoundTrip_mode(t *testing.T) {
+	t.Parallel()
+
+	transport := newTransport(t)
+	loc, _ := url.Parse("https://example.com?_k6=1")
+	cached := &reply{header: http.Header{}, body: []byte("cached")}
+
+	roundTrip := func(mod mode) (*tripperware, string) {
+		tw := newTripperware(mod, transport, logrus.StandardLogger()) // nolint:varnamelen
+
+		tw.history.put(loc, &reply{header: cloneHeader(cached.header), body: cached.body})
+
+		req := new(http.Request)
+		req.URL = loc
+
+		res, err := tw.RoundTrip(req)
+
+		assert.NoError(t, err)
+
+		defer res.Body.Close()
+
+		body, err := io.ReadAll(res.Body)
+
+		assert.NoError(t, err)
+
+		return tw, string(body)
+	}
+
+	tw, body := roundTrip(modeReplay) // nolint:varnamelen
+
+	assert.Equal(t, "cached", body)
+
+	tw, body = roundTrip(modeRefresh)
+
+	assert.Equal(t, "Hello World!", body)
+
+	rep, _ := tw.history.get(loc)
+
+	assert.Equal(t, "Hello World!", string(rep.body))
+
+	tw, body = roundTrip(modePassthrough)
+
+	assert.Equal(t, "Hello World!", body)
+
+	rep, _ = tw.history.get(loc)
+
+	assert.Equal(t, "cached", string(rep.body))
+
+	tw = newTripperware(modeReplay, transport, logrus.StandardLogger())
+
+	req := new(http.Request)
+	req.URL = loc
+
+	_, err := tw.RoundTrip(req) // nolint:bodyclose
+
+	assert.NoError(t, err)
+	assert.Empty(t, tw.history.store)
+}
+
+func TestTripperware_RoundTrip_offline(t *testing.T) {
+	t.Parallel()
+
+	transport := newTransport(t)
+
+	tw := newTripperware(modeOffline, transport, logrus.StandardLogger()) // nolint:varnamelen
+
+	tw.filename = "vendor.eml"
+
+	loc, _ := url.Parse("https://example.com?_k6=1")
+
+	tw.history.put(loc, &reply{header: http.Header{}, body: []byte("cached")})
+
+	req := new(http.Request)
+	req.URL = loc
+
+	res, err := tw.RoundTrip(req)
+
+	assert.NoError(t, err)
+	assert.NoError(t, res.Body.Close())
+
+	req = new(http.Request)
+	req.URL, _ = url.Parse("https://example.com/missing.js")
+
+	_, err = tw.RoundTrip(req) // nolint:bodyclose
+
+	assert.ErrorIs(t, err, errCacheMiss)
+	assert.ErrorContains(t, err, "https://example.com/missing.js")
+	assert.ErrorContains(t, err, "vendor.eml")
+}
+
+func TestTripperware_RoundTrip_policy(t *testing.T) {
+	t.Parallel()
+
+	transport := newTransport(t)
+
+	tw := newTripperware(modeUpdate, transport, logrus.StandardLogger()) // nolint:varnamelen
+
+	tw.policy.denyHosts = []string{"example.com"}
+
+	req := new(http.Request)
+	req.URL, _ = url.Parse("https://example.com/index.js")
+
+	res, err := tw.RoundTrip(req)
+
+	assert.NoError(t, err)
+	assert.NoError(t, res.Body.Close())
+	assert.Empty(t, tw.history.store)
+
+	tw.mode = modeOffline
+
+	_, err = tw.RoundTrip(req) // nolint:bodyclose
+
+	assert.ErrorIs(t, err, errCacheMiss)
+	assert.ErrorContains(t, err, "https://example.com/index.js")
+}
+
+func TestTripperware_RoundTrip_integrity(t *testing.T) {
+	t.Parallel()
+
+	transport := newTransport(t)
+
+	tw := newTripperware(modeRecord, transport, logrus.StandardLogger()) // nolint:varnamelen
+
+	valid := integrity([]byte("Hello World!"))
+
+	for range []int{1, 2} { // miss and hit
+		req := new(http.Request)
+		req.URL, _ = url.Parse("https://example.com/index.js?_k6=1#" + valid)
+
+		res, err := tw.RoundTrip(req)
+
+		assert.NoError(t, err)
+
+		body, err := io.ReadAll(res.Body)
+
+		assert.NoError(t, err)
+		assert.NoError(t, res.Body.Close())
+		assert.Equal(t, "Hello World!", string(body))
+		assert.Equal(t, valid, req.URL.Fragment)
+	}
+
+	assert.Len(t, tw.history.store, 2)
+
+	_, found := tw.history.get(&url.URL{Scheme: "https", Host: "example.com", Path: "/index.js", RawQuery: "_k6=1"})
+
+	assert.True(t, found)
+
+	req := new(http.Request)
+	req.URL, _ = url.Parse("https://example.com/index.js?_k6=1#" + integrity([]byte("other")))
+
+	_, err := tw.RoundTrip(req) // nolint:bodyclose
+
+	assert.ErrorIs(t, err, errIntegrityMismatch)
+	assert.ErrorContains(t, err, "https://example.com/index.js")
+}
+
+func TestTripperware_RoundTrip_integrityMismatch(t *testing.T) {
+	t.Parallel()
+
+	transport := newTransport(t)
+
+	tw := newTripperware(modeRecord, transport, logrus.StandardLogger()) // nolint:varnamelen
+
+	req := new(http.Request)
+	req.URL, _ = url.Parse("https://example.com/index.js?_k6=1#" + integrity([]byte("other")))
+
+	_, err := tw.RoundTrip(req) // nolint:bodyclose
+
+	assert.ErrorIs(t, err, errIntegrityMismatch)
+	assert.Empty(t, tw.history.keys())
+	assert.Zero(t, tw.recorded.Load())
+}
+
+func TestTripperware_RoundTrip_integrityRedirect(t *testing.T) {
+	t.Parallel()
+
+	get := func(tw *tripperware, body string) error {
+		client := &http.Client{Transport: tw} //nolint:exhaustruct
+
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet,
+			"https://unpkg.com/lodash?_k6=1#"+integrity([]byte(body)), nil)
+
+		assert.NoError(t, err)
+
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+
+		return res.Body.Close()
+	}
+
+	target := &url.URL{Scheme: "https", Host: "unpkg.com", Path: "/lodash@4.17.21/lodash.js", RawQuery: "_k6=1"}
+
+	tw := newTripperware(modeRecord, new(redirectTransport), logrus.StandardLogger()) // nolint:varnamelen
+
+	assert.ErrorIs(t, get(tw, "evil"), errIntegrityMismatch)
+
+	_, found := tw.history.get(target)
+
+	assert.False(t, found)
+
+	assert.NoError(t, get(tw, "// /lodash@4.17.21/lodash.js"))
+
+	_, found = tw.history.get(target)
+
+	assert.True(t, found)
+
+	assert.ErrorIs(t, get(tw, "evil"), errIntegrityMismatch)
+}
@@ -0,0 +1,92 @@
+// SPDX-FileCopyrightText: 2023 Iván Szkiba
+//
+// SPDX-License-Identifier: MIT
+
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// formatVersion is the version of the cache format written by this release.
+// Files without version header are version 1.
+const formatVersion = 4
+
+// migrations upgrade an entry from the format version of the key to the next one.
+// A nil migration means that the entries of the version are valid in the next version as is.
+var migrations = map[int]func(rep *reply) error{
+	// version 1 entries may lack the Integrity header, it is added by history.put
+	1: nil,
+	// version 3 keys entries by canonical URL
+	2: canonicalizeLocation,
+	// version 4 adds redirect entries, version 3 entries are all 200
+	3: nil,
+}
+
+// canonicalizeLocation replaces the Content-Location of an entry with its canonical form.
+func canonicalizeLocation(rep *reply) error {
+	loc, err := url.Parse(rep.header.Get(hdrContentLocation))
+	if err != nil {
+		return err
+	}
+
+	rep.header.Set(hdrContentLocation, canonicalKey(loc))
+
+	return nil
+}
+
+// parseFormatVersion parses the value of the format version header.
+// An empty value means version 1, versions newer than formatVersion are rejected.
+func parseFormatVersion(str string) (int, error) {
+	if len(str) == 0 {
+		return 1, nil
+	}
+
+	version, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", errInvalidFormatVersion, str)
+	}
+
+	if err := checkFormatVersion(version); err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// checkFormatVersion returns an error if version cannot be read by this release.
+func checkFormatVersion(version int) error {
+	if version < 1 {
+		return fmt.Errorf("%w: %d", errInvalidFormatVersion, version)
+	}
+
+	if version > formatVersion {
+		return fmt.Errorf("%w: version %d, supported up to version %d, please upgrade %s",
+			errNewerFormatVersion, version, formatVersion, xk6Name)
+	}
+
+	return nil
+}
+
+// migrate upgrades an entry of the given format version to formatVersion.
+func migrate(rep *reply, version int) error {
+	for ; version < formatVersion; version++ {
+		if fn := migrations[version]; fn != nil {
+			if err := fn(rep); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+const hdrFormatVersion = "X-Xk6-Cache-Version"
+
+var (
+	errInvalidFormatVersion = errors.New("invalid cache format version")
+	errNewerFormatVersion   = errors.New("cache written by a newer release")
+)
@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFormatVersion(t *testing.T) {
+	t.Parallel()
+
+	version, err := parseFormatVersion("")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, version)
+
+	version, err = parseFormatVersion("2")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, version)
+
+	_, err = parseFormatVersion("foo")
+
+	assert.ErrorIs(t, err, errInvalidFormatVersion)
+
+	_, err = parseFormatVersion("0")
+
+	assert.ErrorIs(t, err, errInvalidFormatVersion)
+
+	_, err = parseFormatVersion("5")
+
+	assert.ErrorIs(t, err, errNewerFormatVersion)
+}
+
+func TestMigrate(t *testing.T) {
+	t.Parallel()
+
+	rep := &reply{header: http.Header{}, body: []byte("Hello World!")}
+
+	rep.header.Set(hdrContentLocation, "HTTPS://Example.COM:443/index.js?b=2&a=1&_k6=1")
+
+	assert.NoError(t, migrate(rep, formatVersion))
+	assert.Equal(t, "HTTPS://Example.COM:443/index.js?b=2&a=1&_k6=1", rep.header.Get(hdrContentLocation))
+
+	assert.NoError(t, migrate(rep, 1))
+	assert.Equal(t, "https://example.com/index.js?_k6=1&a=1&b=2", rep.header.Get(hdrContentLocation))
+	assert.Equal(t, "Hello World!", string(rep.body))
+
+	rep.header.Set(hdrContentLocation, "%")
+
+	assert.Error(t, migrate(rep, 2))
+}
+
+func TestReadManifest_version(t *testing.T) {
+	t.Parallel()
+
+	man, err := readManifest(strings.NewReader(`{"entries":[]}`))
+
+	assert.NoError(t, err)
+	assert.Equal(t, manifestBaseVersion, man.Version)
+
+	_, err = readManifest(strings.NewReader(`{"version":5,"entries":[]}`))
+
+	assert.ErrorIs(t, err, errNewerFormatVersion)
+	assert.Equal(t, formatVersion, layout(new(history)).Version)
+}